@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/subcommands"
+)
+
+type checkUpstreamCmd struct {
+	dir      string
+	parallel int
+	timeout  time.Duration
+	perHost  int
+
+	hosts *hostLimiter
+}
+
+func (c checkUpstreamCmd) Name() string     { return "check-upstream" }
+func (c checkUpstreamCmd) Synopsis() string { return "check repository remotes still exist" }
+func (c checkUpstreamCmd) Usage() string {
+	return "repos check-upstream [-dir=PATH] [-parallel=N] [-timeout=DURATION] [-concurrency-per-host=N]\n"
+}
+
+func (c *checkUpstreamCmd) SetFlags(fset *flag.FlagSet) {
+	fset.StringVar(&c.dir, "dir", envOr(RootEnv, "."), "directory of repos to check, defaults to $"+RootEnv+" or the current directory")
+	fset.IntVar(&c.parallel, "parallel", 5, "parallel checks to run")
+	fset.DurationVar(&c.timeout, "timeout", 30*time.Second, "timeout for checking each remote")
+	fset.IntVar(&c.perHost, "concurrency-per-host", 0, "max concurrent checks against each remote host, 0 for no limit")
+}
+
+func (c checkUpstreamCmd) Execute(ctx context.Context, fset *flag.FlagSet, args ...any) subcommands.ExitStatus {
+	if fset.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "repos check-upstream: unexpected args:", args)
+		return subcommands.ExitUsageError
+	}
+
+	err := c.run(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "repos check-upstream:", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+func (c checkUpstreamCmd) run(ctx context.Context) error {
+	repoDirs, err := listRepoDirs(c.dir, false)
+	if err != nil {
+		return err
+	}
+
+	c.hosts = newHostLimiter(c.perHost)
+	statuses := make([]string, len(repoDirs))
+	forEachParallel(len(repoDirs), c.parallel, func(i int) {
+		statuses[i] = c.checkUpstream(ctx, repoDirs[i])
+	})
+
+	var bad int
+	for i, dir := range repoDirs {
+		if statuses[i] != "ok" {
+			bad++
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s\n", filepath.Base(dir), statuses[i])
+	}
+	if bad > 0 {
+		return fmt.Errorf("%d of %d remotes need attention", bad, len(repoDirs))
+	}
+	return nil
+}
+
+var redirectRe = regexp.MustCompile(`redirecting to (\S+)`)
+
+// checkUpstream describes the state of a repo's origin.
+func (c checkUpstreamCmd) checkUpstream(ctx context.Context, dir string) string {
+	wd, err := gitWorkDir(ctx, dir)
+	if err != nil {
+		return err.Error()
+	}
+	out, err := lsRemote(ctx, c.hosts, wd, c.timeout)
+	msg := string(out)
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		return "canceled"
+	case err != nil && strings.Contains(msg, "not found"):
+		return "gone"
+	case err != nil && (strings.Contains(msg, "Authentication failed") ||
+		strings.Contains(msg, "could not read Username") ||
+		strings.Contains(msg, "Permission denied")):
+		return "unauthorized"
+	case err != nil:
+		return "unreachable: " + strings.TrimSpace(firstLine(msg, err))
+	}
+	if m := redirectRe.FindStringSubmatch(msg); m != nil {
+		return "renamed to " + m[1]
+	}
+	return "ok"
+}
+
+func firstLine(msg string, err error) string {
+	if line, _, _ := strings.Cut(strings.TrimSpace(msg), "\n"); line != "" {
+		return line
+	}
+	return err.Error()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/subcommands"
+)
+
+type cleanCmd struct {
+	testDir   string
+	prefix    string
+	nameTpl   string
+	olderThan time.Duration
+	dryRun    bool
+	force     bool
+}
+
+func (c cleanCmd) Name() string     { return "clean" }
+func (c cleanCmd) Synopsis() string { return "remove old test repos" }
+func (c cleanCmd) Usage() string {
+	return `repos clean [-test-dir=DIR] [-prefix=testrepo] [-name-template=TPL] [-older-than=DURATION] [-dryrun] [-force]
+
+Test repos not modified within -older-than are removed.
+Without -force, repos with uncommitted changes or unpushed commits are kept,
+as are directories that aren't git repos.
+`
+}
+
+func (c *cleanCmd) SetFlags(fset *flag.FlagSet) {
+	fset.StringVar(&c.testDir, "test-dir", "", "directory of test repos (default ~/tmp)")
+	fset.StringVar(&c.prefix, "prefix", defaultPrefix, "name prefix of test repos")
+	fset.StringVar(&c.nameTpl, "name-template", defaultNameTemplate, "template test repos were named with")
+	fset.DurationVar(&c.olderThan, "older-than", 7*24*time.Hour, "remove test repos not modified for this long")
+	fset.BoolVar(&c.dryRun, "dryrun", false, "print actions instead of executing them")
+	fset.BoolVar(&c.force, "force", false, "remove test repos even if they have local work or aren't git repos")
+}
+
+func (c cleanCmd) Execute(ctx context.Context, fset *flag.FlagSet, args ...any) subcommands.ExitStatus {
+	if fset.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "repos clean: unexpected args:", args)
+		return subcommands.ExitUsageError
+	}
+	_, err := testRepoConfig(fset, &c.testDir, &c.prefix, &c.nameTpl)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "repos clean:", err)
+		return subcommands.ExitFailure
+	}
+	if !validName(c.prefix) {
+		fmt.Fprintln(os.Stderr, "repos clean: invalid prefix:", c.prefix)
+		return subcommands.ExitUsageError
+	}
+	err = c.run(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "repos clean:", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+func (c cleanCmd) run(ctx context.Context) error {
+	prefix, err := namePrefix(c.nameTpl, c.prefix)
+	if err != nil {
+		return err
+	}
+	if prefix == "" {
+		return fmt.Errorf("name template has no fixed prefix to find test repos by")
+	}
+	tmpDir, err := testDir(c.testDir)
+	if err != nil {
+		return err
+	}
+	des, err := os.ReadDir(tmpDir)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", tmpDir, err)
+	}
+
+	cutoff := time.Now().Add(-c.olderThan)
+	for _, de := range des {
+		if !strings.HasPrefix(de.Name(), prefix) || !de.IsDir() {
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil || fi.ModTime().After(cutoff) {
+			continue
+		}
+
+		fp := filepath.Join(tmpDir, de.Name())
+		if !c.force {
+			if reason := localWork(ctx, fp); reason != "" {
+				fmt.Fprintf(os.Stderr, "not removing %s: %s\n", fp, reason)
+				continue
+			}
+		}
+
+		msg := "rm -rf " + fp
+		if !c.dryRun {
+			err := os.RemoveAll(fp)
+			if err != nil {
+				msg += ": " + err.Error()
+			}
+		}
+		fmt.Fprintln(os.Stderr, msg)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/subcommands"
+)
+
+type completionCmd struct{}
+
+func (c completionCmd) Name() string     { return "completion" }
+func (c completionCmd) Synopsis() string { return "print a shell completion script" }
+func (c completionCmd) Usage() string {
+	return `repos completion bash|zsh|fish
+
+Completes subcommand names and their flags.
+The script is printed to stdout, load it with for example:
+
+	source <(command repos completion bash)
+`
+}
+func (c completionCmd) SetFlags(fset *flag.FlagSet) {}
+
+// completionEntry is a subcommand and the flags it accepts.
+type completionEntry struct {
+	name, synopsis string
+	flags          []*flag.Flag
+}
+
+func (c completionCmd) Execute(ctx context.Context, fset *flag.FlagSet, args ...any) subcommands.ExitStatus {
+	if fset.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "repos completion: expected one of bash, zsh, fish")
+		return subcommands.ExitUsageError
+	}
+
+	var entries []completionEntry
+	subcommands.DefaultCommander.VisitCommands(func(_ *subcommands.CommandGroup, cmd subcommands.Command) {
+		cfset := flag.NewFlagSet(cmd.Name(), flag.ContinueOnError)
+		cmd.SetFlags(cfset)
+		e := completionEntry{name: cmd.Name(), synopsis: cmd.Synopsis()}
+		cfset.VisitAll(func(f *flag.Flag) {
+			e.flags = append(e.flags, f)
+		})
+		entries = append(entries, e)
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	var script string
+	switch fset.Arg(0) {
+	case "bash":
+		script = bashCompletion(entries)
+	case "zsh":
+		script = zshCompletion(entries)
+	case "fish":
+		script = fishCompletion(entries)
+	default:
+		fmt.Fprintln(os.Stderr, "repos completion: unknown shell", fset.Arg(0))
+		return subcommands.ExitUsageError
+	}
+	fmt.Print(script)
+	return subcommands.ExitSuccess
+}
+
+func (e completionEntry) flagWords() string {
+	words := make([]string, 0, len(e.flags))
+	for _, f := range e.flags {
+		words = append(words, "-"+f.Name)
+	}
+	return strings.Join(words, " ")
+}
+
+func completionNames(entries []completionEntry) string {
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.name)
+	}
+	return strings.Join(names, " ")
+}
+
+func bashCompletion(entries []completionEntry) string {
+	var b strings.Builder
+	b.WriteString("_repos() {\n")
+	b.WriteString("\tlocal cur=${COMP_WORDS[COMP_CWORD]}\n")
+	b.WriteString("\tif [[ $COMP_CWORD -eq 1 ]]; then\n")
+	fmt.Fprintf(&b, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", completionNames(entries))
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\tfi\n")
+	b.WriteString("\tcase ${COMP_WORDS[1]} in\n")
+	for _, e := range entries {
+		if len(e.flags) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\t%s) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", e.name, e.flagWords())
+	}
+	b.WriteString("\tesac\n")
+	b.WriteString("}\n")
+	b.WriteString("complete -o default -F _repos repos\n")
+	return b.String()
+}
+
+func zshCompletion(entries []completionEntry) string {
+	var b strings.Builder
+	b.WriteString("#compdef repos\n")
+	b.WriteString("_repos() {\n")
+	b.WriteString("\tif (( CURRENT == 2 )); then\n")
+	fmt.Fprintf(&b, "\t\tcompadd -- %s\n", completionNames(entries))
+	b.WriteString("\t\treturn\n")
+	b.WriteString("\tfi\n")
+	b.WriteString("\tcase $words[2] in\n")
+	for _, e := range entries {
+		if len(e.flags) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\t%s) compadd -- %s ;;\n", e.name, e.flagWords())
+	}
+	b.WriteString("\t*) _files ;;\n")
+	b.WriteString("\tesac\n")
+	b.WriteString("}\n")
+	b.WriteString("compdef _repos repos\n")
+	return b.String()
+}
+
+func fishCompletion(entries []completionEntry) string {
+	var b strings.Builder
+	b.WriteString("complete -c repos -f\n")
+	for _, e := range entries {
+		fmt.Fprintf(&b, "complete -c repos -n __fish_use_subcommand -a %s -d %s\n", e.name, fishQuote(e.synopsis))
+	}
+	for _, e := range entries {
+		for _, f := range e.flags {
+			fmt.Fprintf(&b, "complete -c repos -n '__fish_seen_subcommand_from %s' -o %s -d %s\n", e.name, f.Name, fishQuote(f.Usage))
+		}
+	}
+	return b.String()
+}
+
+// fishQuote single quotes s for fish, which only treats \\ and \' specially in them.
+func fishQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `'`, `\'`)
+	return "'" + s + "'"
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/google/subcommands"
+)
+
+const doctorTimeout = 10 * time.Second
+
+type doctorCmd struct {
+	dir string
+}
+
+func (c doctorCmd) Name() string     { return "doctor" }
+func (c doctorCmd) Synopsis() string { return "check the environment repos needs" }
+func (c doctorCmd) Usage() string {
+	return `repos doctor [-dir=PATH]
+
+Checks for git and go, the git identity, GH_TOKEN against the github api,
+and that the repos root exists and is writable.
+Exits non-zero if git or the repos root are unusable.
+`
+}
+
+func (c *doctorCmd) SetFlags(fset *flag.FlagSet) {
+	fset.StringVar(&c.dir, "dir", envOr(RootEnv, "."), "repos root to check, defaults to $"+RootEnv+" or the current directory")
+}
+
+// doctorCheck is a single line of the doctor checklist.
+type doctorCheck struct {
+	name     string
+	critical bool // failing makes repos unusable, not just some commands
+	run      func(ctx context.Context) (string, error)
+}
+
+func (c doctorCmd) Execute(ctx context.Context, fset *flag.FlagSet, args ...any) subcommands.ExitStatus {
+	if fset.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "repos doctor: unexpected args:", args)
+		return subcommands.ExitUsageError
+	}
+
+	checks := []doctorCheck{
+		{"git", true, lookPathCheck("git")},
+		{"git identity", false, checkGitIdentity},
+		{"go", false, lookPathCheck("go")},
+		{GithubTokenEnv, false, checkGitHubToken},
+		{"repos root", true, c.checkRoot},
+	}
+	var failed bool
+	for _, check := range checks {
+		ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+		detail, err := check.run(ctx)
+		cancel()
+		status := "ok  "
+		if err != nil {
+			status = "warn"
+			if check.critical {
+				status = "FAIL"
+				failed = true
+			}
+			detail = err.Error()
+		}
+		fmt.Fprintf(os.Stderr, "%s %s: %s\n", status, check.name, detail)
+	}
+	if failed {
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+func lookPathCheck(bin string) func(context.Context) (string, error) {
+	return func(context.Context) (string, error) {
+		return exec.LookPath(bin)
+	}
+}
+
+func checkGitIdentity(ctx context.Context) (string, error) {
+	var ident []string
+	for _, key := range []string{"user.name", "user.email"} {
+		out, err := exec.CommandContext(ctx, "git", "config", "--get", key).Output()
+		v := strings.TrimSpace(string(out))
+		if err != nil || v == "" {
+			return "", fmt.Errorf("%s not set, commits in new repos will fail", key)
+		}
+		ident = append(ident, v)
+	}
+	return strings.Join(ident, " "), nil
+}
+
+func checkGitHubToken(ctx context.Context) (string, error) {
+	if os.Getenv(GithubTokenEnv) == "" {
+		return "", errors.New("unset, syncgh and other github commands will fail")
+	}
+	client, err := newGitHubClient(ctx, githubApp{}, os.Getenv(GithubBaseURLEnv))
+	if err != nil {
+		return "", err
+	}
+	user, _, err := client.Users.Get(ctx, "")
+	if err != nil {
+		return "", fmt.Errorf("get authenticated user: %w", err)
+	}
+	return "authenticated as " + user.GetLogin(), nil
+}
+
+func (c doctorCmd) checkRoot(context.Context) (string, error) {
+	fi, err := os.Stat(c.dir)
+	if err != nil {
+		return "", err
+	} else if !fi.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", c.dir)
+	}
+	f, err := os.CreateTemp(c.dir, ".repos-doctor-*")
+	if err != nil {
+		return "", fmt.Errorf("not writable: %w", err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return c.dir, nil
+}
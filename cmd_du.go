@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/google/subcommands"
+)
+
+type duCmd struct {
+	dir      string
+	parallel int
+	json     bool
+}
+
+func (c duCmd) Name() string     { return "du" }
+func (c duCmd) Synopsis() string { return "report disk usage of repositories" }
+func (c duCmd) Usage() string    { return "repos du [-dir=PATH] [-parallel=N] [-json]\n" }
+func (c *duCmd) SetFlags(fset *flag.FlagSet) {
+	fset.StringVar(&c.dir, "dir", envOr(RootEnv, "."), "directory of repos to measure, defaults to $"+RootEnv+" or the current directory")
+	fset.IntVar(&c.parallel, "parallel", 5, "parallel size computations to run")
+	fset.BoolVar(&c.json, "json", false, "print json to stdout")
+}
+
+func (c duCmd) Execute(ctx context.Context, fset *flag.FlagSet, args ...any) subcommands.ExitStatus {
+	if fset.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "repos du: unexpected args:", args)
+		return subcommands.ExitUsageError
+	}
+
+	err := c.run(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "repos du:", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+type duResult struct {
+	Dir     string `json:"dir"`
+	Size    int64  `json:"size"`
+	GitSize int64  `json:"git_size"`
+	Err     string `json:"error,omitempty"`
+}
+
+func (c duCmd) run(ctx context.Context) error {
+	repoDirs, err := listRepoDirs(c.dir, false)
+	if err != nil {
+		return err
+	}
+
+	results := make([]duResult, len(repoDirs))
+	forEachParallel(len(repoDirs), c.parallel, func(i int) {
+		if ctx.Err() != nil {
+			results[i] = duResult{Dir: filepath.Base(repoDirs[i]), Err: ctx.Err().Error()}
+			return
+		}
+		results[i] = repoUsage(ctx, repoDirs[i])
+	})
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Size > results[j].Size
+	})
+
+	if c.json {
+		enc := json.NewEncoder(os.Stdout)
+		for _, res := range results {
+			err := enc.Encode(res)
+			if err != nil {
+				return fmt.Errorf("du: write json: %w", err)
+			}
+		}
+		return nil
+	}
+
+	var total, totalGit int64
+	fmt.Fprintf(os.Stderr, "%10s %10s %s\n", "size", "git", "repo")
+	for _, res := range results {
+		total += res.Size
+		totalGit += res.GitSize
+		msg := fmt.Sprintf("%10s %10s %s", formatBytes(res.Size), formatBytes(res.GitSize), res.Dir)
+		if res.Err != "" {
+			msg += ": " + res.Err
+		}
+		fmt.Fprintln(os.Stderr, msg)
+	}
+	fmt.Fprintf(os.Stderr, "%10s %10s %s\n", formatBytes(total), formatBytes(totalGit), "total")
+	return nil
+}
+
+func repoUsage(ctx context.Context, dir string) duResult {
+	res := duResult{
+		Dir: filepath.Base(dir),
+	}
+	size, err := dirSize(dir)
+	if err != nil {
+		res.Err = err.Error()
+	}
+	res.Size = size
+
+	if wd, err := gitWorkDir(ctx, dir); err == nil {
+		// a bare mirror is all git data
+		gitDir := filepath.Join(wd, ".git")
+		if isBareRepo(ctx, wd) {
+			gitDir = wd
+		}
+		res.GitSize, _ = dirSize(gitDir)
+	}
+	return res
+}
+
+// dirSize sums the sizes of regular files under dir,
+// not following symlinks below dir itself.
+func dirSize(dir string) (int64, error) {
+	// WalkDir doesn't descend into a symlinked root
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += fi.Size()
+		return nil
+	})
+	return size, err
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%c", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/google/subcommands"
+)
+
+type envCmd struct{}
+
+func (c envCmd) Name() string                { return "env" }
+func (c envCmd) Synopsis() string            { return "print the environment repos runs with" }
+func (c envCmd) Usage() string               { return "repos env\n" }
+func (c envCmd) SetFlags(fset *flag.FlagSet) {}
+func (c envCmd) Execute(ctx context.Context, fset *flag.FlagSet, args ...any) subcommands.ExitStatus {
+	if fset.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "repos env: unexpected args:", args)
+		return subcommands.ExitUsageError
+	}
+
+	for _, e := range []string{GithubTokenEnv, "GITHUB_TOKEN", GitlabTokenEnv} {
+		fmt.Fprintf(os.Stderr, "%s=%s\n", e, redactEnv(e))
+	}
+	for _, e := range []string{
+		GithubBaseURLEnv, GithubAppIDEnv, GithubAppInstallationIDEnv, GithubAppKeyEnv,
+		RootEnv, ModulePrefixEnv, RemoteAliasEnv, GithubOwnerEnv,
+	} {
+		fmt.Fprintf(os.Stderr, "%s=%s\n", e, os.Getenv(e))
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "error: " + err.Error()
+	}
+	fmt.Fprintf(os.Stderr, "home dir: %s\n", homeDir)
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		cacheDir = "error: " + err.Error()
+	}
+	fmt.Fprintf(os.Stderr, "cache dir: %s\n", cacheDir)
+	configPath := defaultConfigPath()
+	if configPath == "" {
+		configPath = "(no config dir)"
+	} else if _, err := os.Stat(configPath); err != nil {
+		configPath += " (not found)"
+	}
+	fmt.Fprintf(os.Stderr, "config file: %s\n", configPath)
+
+	for _, bin := range []string{"git", "go"} {
+		p, err := exec.LookPath(bin)
+		if err != nil {
+			p = "not found"
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s\n", bin, p)
+	}
+	return subcommands.ExitSuccess
+}
+
+// redactEnv reports whether a secret env var is set without revealing it.
+func redactEnv(key string) string {
+	v, ok := os.LookupEnv(key)
+	switch {
+	case !ok:
+		return "(unset)"
+	case v == "":
+		return "(empty)"
+	default:
+		return fmt.Sprintf("(set, %d chars)", len(v))
+	}
+}
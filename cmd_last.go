@@ -7,22 +7,43 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/google/subcommands"
 )
 
-type lastCmd struct{}
+type lastCmd struct {
+	testDir string
+	prefix  string
+	nameTpl string
+}
+
+func (c lastCmd) Name() string     { return "last" }
+func (c lastCmd) Synopsis() string { return "jumps to the most recently created test repo" }
+func (c lastCmd) Usage() string {
+	return "repos last [-test-dir=DIR] [-prefix=testrepo] [-name-template=TPL]\n"
+}
+func (c *lastCmd) SetFlags(fset *flag.FlagSet) {
+	fset.StringVar(&c.testDir, "test-dir", "", "directory of test repos (default ~/tmp)")
+	fset.StringVar(&c.prefix, "prefix", defaultPrefix, "name prefix of test repos")
+	fset.StringVar(&c.nameTpl, "name-template", defaultNameTemplate, "template test repos were named with")
+}
 
-func (c lastCmd) Name() string                { return "last" }
-func (c lastCmd) Synopsis() string            { return "jumps to the most recently created test repo" }
-func (c lastCmd) Usage() string               { return "repos last\n" }
-func (c lastCmd) SetFlags(fset *flag.FlagSet) {}
 func (c lastCmd) Execute(ctx context.Context, fset *flag.FlagSet, args ...any) subcommands.ExitStatus {
 	if fset.NArg() > 0 {
 		fmt.Fprintln(os.Stderr, "repos last: unexpected args:", args)
 		return subcommands.ExitUsageError
 	}
-	err := c.run(ctx)
+	_, err := testRepoConfig(fset, &c.testDir, &c.prefix, &c.nameTpl)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "repos last:", err)
+		return subcommands.ExitFailure
+	}
+	if !validName(c.prefix) {
+		fmt.Fprintln(os.Stderr, "repos last: invalid prefix:", c.prefix)
+		return subcommands.ExitUsageError
+	}
+	err = c.run(ctx)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "repos last:", err)
 		return subcommands.ExitFailure
@@ -31,25 +52,42 @@ func (c lastCmd) Execute(ctx context.Context, fset *flag.FlagSet, args ...any) s
 }
 
 func (c lastCmd) run(ctx context.Context) error {
-	homeDir, err := os.UserHomeDir()
+	prefix, err := namePrefix(c.nameTpl, c.prefix)
+	if err != nil {
+		return fmt.Errorf("tmp: %w", err)
+	}
+	tmpDir, err := testDir(c.testDir)
 	if err != nil {
-		return fmt.Errorf("tmp: get home directory: %w", err)
+		return fmt.Errorf("tmp: %w", err)
 	}
-	tmpDir := filepath.Join(homeDir, "tmp")
 	des, err := os.ReadDir(tmpDir)
 	if err != nil {
 		return fmt.Errorf("tmp: read %s: %w", tmpDir, err)
 	}
+	// most recently modified, breaking ties by name
 	var last string
+	var lastMod time.Time
 	for _, de := range des {
-		if n := de.Name(); strings.HasPrefix(n, "testrepo") && n > last {
-			last = n
+		n := de.Name()
+		if !strings.HasPrefix(n, prefix) || !de.IsDir() {
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil {
+			continue
+		}
+		if mod := fi.ModTime(); last == "" || mod.After(lastMod) || mod.Equal(lastMod) && n > last {
+			last, lastMod = n, mod
 		}
 	}
 	if last == "" {
 		return fmt.Errorf("tmp: no repo found")
 	}
 
-	fmt.Printf("cd %s\n", filepath.Join(tmpDir, last))
+	fp := filepath.Join(tmpDir, last)
+	if fi, err := os.Stat(filepath.Join(fp, "default")); err == nil && fi.IsDir() {
+		fp = filepath.Join(fp, "default")
+	}
+	fmt.Printf("cd %s\n", fp)
 	return nil
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/subcommands"
+)
+
+type listCmd struct {
+	dir          string
+	parallel     int
+	json         bool
+	sortBy       string
+	groupByOwner bool
+}
+
+func (c listCmd) Name() string     { return "list" }
+func (c listCmd) Synopsis() string { return "list repositories with their branch, HEAD and state" }
+func (c listCmd) Usage() string {
+	return `repos list [-dir=PATH] [-parallel=N] [-json] [-sort=name|mtime|age|size] [-group-by-owner]
+
+Prints a table of the repos under -dir to stderr, or json lines to stdout with -json.
+-sort=mtime lists the most recently modified checkouts first,
+-sort=age the most recently committed to first,
+and -sort=size the largest repos on disk first.
+`
+}
+
+func (c *listCmd) SetFlags(fset *flag.FlagSet) {
+	fset.StringVar(&c.dir, "dir", envOr(RootEnv, "."), "directory of repos to list, defaults to $"+RootEnv+" or the current directory")
+	fset.IntVar(&c.parallel, "parallel", 5, "parallel repo inspections to run")
+	fset.BoolVar(&c.json, "json", false, "print json to stdout")
+	fset.StringVar(&c.sortBy, "sort", "name", "order of the repos: name, mtime, age or size")
+	fset.BoolVar(&c.groupByOwner, "group-by-owner", false, "repos are nested under owner directories, as cloned by syncgh -group-by-owner")
+}
+
+func (c listCmd) Execute(ctx context.Context, fset *flag.FlagSet, args ...any) subcommands.ExitStatus {
+	if fset.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "repos list: unexpected args:", args)
+		return subcommands.ExitUsageError
+	}
+	switch c.sortBy {
+	case "name", "mtime", "age", "size":
+	default:
+		fmt.Fprintln(os.Stderr, "repos list: -sort must be one of name, mtime, age, size")
+		return subcommands.ExitUsageError
+	}
+
+	err := c.run(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "repos list:", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+// listEntry describes the checkout of a repo.
+type listEntry struct {
+	Name       string    `json:"name"`
+	Branch     string    `json:"branch"`
+	Head       string    `json:"head"`
+	Dirty      bool      `json:"dirty"`
+	Remote     string    `json:"remote"`
+	ModTime    time.Time `json:"mtime"`
+	LastCommit time.Time `json:"last_commit"`
+	Size       int64     `json:"size,omitempty"` // only measured for -sort=size
+	Error      string    `json:"error,omitempty"`
+}
+
+func (c listCmd) run(ctx context.Context) error {
+	repoDirs, err := listRepoDirs(c.dir, c.groupByOwner)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]listEntry, len(repoDirs))
+	isRepo := make([]bool, len(repoDirs))
+	forEachParallel(len(repoDirs), c.parallel, func(i int) {
+		if ctx.Err() != nil {
+			entries[i] = listEntry{Name: c.repoName(repoDirs[i]), Error: ctx.Err().Error()}
+			isRepo[i] = true
+			return
+		}
+		entries[i], isRepo[i] = c.inspect(ctx, repoDirs[i])
+	})
+
+	var repos []listEntry
+	for i, e := range entries {
+		if isRepo[i] {
+			repos = append(repos, e)
+		}
+	}
+	sort.SliceStable(repos, func(i, j int) bool {
+		switch {
+		case c.sortBy == "mtime" && !repos[i].ModTime.Equal(repos[j].ModTime):
+			return repos[i].ModTime.After(repos[j].ModTime)
+		case c.sortBy == "age" && !repos[i].LastCommit.Equal(repos[j].LastCommit):
+			return repos[i].LastCommit.After(repos[j].LastCommit)
+		case c.sortBy == "size" && repos[i].Size != repos[j].Size:
+			return repos[i].Size > repos[j].Size
+		}
+		return repos[i].Name < repos[j].Name
+	})
+
+	if c.json {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range repos {
+			err := enc.Encode(e)
+			if err != nil {
+				return fmt.Errorf("write json: %w", err)
+			}
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tBRANCH\tHEAD\tSTATE\tREMOTE")
+	for _, e := range repos {
+		state := "clean"
+		if e.Error != "" {
+			state = "error: " + e.Error
+		} else if e.Dirty {
+			state = "dirty"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", e.Name, e.Branch, e.Head, state, e.Remote)
+	}
+	return tw.Flush()
+}
+
+// repoName is dir relative to the repos root.
+func (c listCmd) repoName(dir string) string {
+	return syncCmd{dir: c.dir}.repoName(dir)
+}
+
+// inspect reads the state of the checkout for dir,
+// reporting false if it isn't a repo.
+func (c listCmd) inspect(ctx context.Context, dir string) (listEntry, bool) {
+	e := listEntry{Name: c.repoName(dir)}
+	wd, err := gitWorkDir(ctx, dir)
+	if errors.Is(err, ErrNoGitDir) {
+		return e, false
+	} else if err != nil {
+		e.Error = err.Error()
+		return e, true
+	}
+	if fi, err := os.Stat(wd); err == nil {
+		e.ModTime = fi.ModTime()
+	}
+
+	git := func(args ...string) string {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = wd
+		out, _ := cmd.Output()
+		return string(bytes.TrimSpace(out))
+	}
+	e.Branch = git("rev-parse", "--abbrev-ref", "HEAD")
+	e.Head = git("rev-parse", "--short", "HEAD")
+	e.Remote = git("remote", "get-url", "origin")
+	// empty for repos without commits, which sort last
+	e.LastCommit, _ = time.Parse("2006-01-02 15:04:05 -0700", git("log", "-1", "--format=%ci"))
+	if c.sortBy == "size" {
+		e.Size, _ = dirSize(dir)
+	}
+	if !isBareRepo(ctx, wd) {
+		e.Dirty, err = isDirty(ctx, wd, true)
+		if err != nil {
+			e.Error = err.Error()
+		}
+	}
+	return e, true
+}
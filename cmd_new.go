@@ -1,52 +1,182 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"os/exec"
+	"os/user"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"text/template"
 	"time"
 
+	"github.com/google/go-github/v48/github"
 	"github.com/google/subcommands"
 )
 
 const (
-	versionFile = "testrepo-version"
+	defaultTestDir      = "tmp" // under the home dir
+	defaultPrefix       = "testrepo"
+	defaultNameTemplate = `{{.Prefix}}{{printf "%04d" .Counter}}`
+	versionSuffix       = "-version"
+	defaultLicense      = "MIT"
+	defaultGitignore    = "go"
+
+	ModulePrefixEnv     = "REPOS_MODULE_PREFIX"
+	defaultModulePrefix = "go.seankhliao.com/"
+	RemoteAliasEnv      = "REPOS_REMOTE_ALIAS"
+	defaultRemoteAlias  = "s:"
+	GithubOwnerEnv      = "REPOS_GITHUB_OWNER"
+	defaultGithubOwner  = "seankhliao"
 )
 
-type newCmd struct{}
+type newCmd struct {
+	remoteStyle string
+	testDir     string
+	prefix      string
+	templateDir string
+	nameTpl     string
+	worktree    bool
+	license     string
+	gitignore   string
+	hook        string
+	github      bool
+	private     bool
+	dryRun      bool
+	owner       string
+}
+
+func (c newCmd) Name() string     { return "new" }
+func (c newCmd) Synopsis() string { return "create a new repository" }
+func (c newCmd) Usage() string {
+	return `repos new [-remote-style=alias|ssh|https] [-test-dir=DIR] [-prefix=testrepo] [-template-dir=DIR] [-name-template=TPL] [-worktree] [-license=SPDX-ID|none] [-gitignore=LANG|none] [-hook=CMD] [-github] [-private] [-dryrun] [-owner=NAME] [-q] [-v] [repo-name]
+
+Without a repo-name, a test repo is created in -test-dir (default ~/tmp),
+named by executing -name-template with:
+  .Prefix   the -prefix flag
+  .Counter  a counter kept per prefix
+  .Date     the current date as 2006-01-02
+  .User     the current user
+
+-license selects one of the builtin licenses: MIT, Apache-2.0, BSD-3-Clause,
+or none to skip creating LICENSE.
+-gitignore selects the builtin .gitignore: go, python, node, or none.
+Templates in -template-dir (default ~/.config/repos/templates) override the builtin ones:
+readme.tpl the README, license.tpl MIT and license-ID.tpl the other licenses,
+gitignore-LANG.tpl the .gitignore for LANG. Missing files fall back to the builtin templates.
+Templates are executed with:
+  .Name    the repo name
+  .Module  the go module path
+  .Remote  the origin url
+  .Owner   the github user or org, -owner or $REPOS_GITHUB_OWNER
+  .Year    the current year, also as .Date
+  .Author  git config user.name
+  .Email   git config user.email
+
+-github creates the repo on github for the GH_TOKEN user, optionally -private,
+and points origin at its ssh url, or its https url with -remote-style=https.
+
+The -hook command is run with sh in the new repo once it is set up,
+with the repo name and path as $1 and $2, and REPOS_NAME, REPOS_PATH in its environment.
+Its output goes to stderr, and the command fails if it does.
+
+-test-dir, -prefix, -name-template, -hook and -template-dir default to
+test_dir, prefix, name_template, new_hook and template_dir from the config file,
+the first three shared with repos last.
+
+The module path is the repo name prefixed by $REPOS_MODULE_PREFIX (default go.seankhliao.com/),
+the alias remote style prefixes it with $REPOS_REMOTE_ALIAS (default s:),
+the ssh and https styles put it under $REPOS_GITHUB_OWNER (default seankhliao).
+-owner puts the repo under another github user or org:
+the module path becomes github.com/OWNER/NAME, the origin url points at OWNER,
+using ssh for the alias style, and -github creates the repo in the OWNER org.
+`
+}
+
+func (c *newCmd) SetFlags(fset *flag.FlagSet) {
+	fset.StringVar(&c.remoteStyle, "remote-style", "alias", "form of the origin url: alias, ssh, https")
+	fset.StringVar(&c.testDir, "test-dir", "", "directory for generated test repos (default ~/tmp)")
+	fset.StringVar(&c.prefix, "prefix", defaultPrefix, "name prefix for generated test repos")
+	fset.StringVar(&c.templateDir, "template-dir", defaultTemplateDir(), "directory with license.tpl and readme.tpl overriding the builtin templates")
+	fset.StringVar(&c.nameTpl, "name-template", defaultNameTemplate, "template for generated test repo names")
+	fset.BoolVar(&c.worktree, "worktree", false, "create the checkout under repo/default")
+	fset.StringVar(&c.license, "license", defaultLicense, "SPDX id of the license to add, or none")
+	fset.StringVar(&c.hook, "hook", "", "shell command to run in the new repo")
+	logFlags(fset)
+	fset.BoolVar(&c.github, "github", false, "create the repo on github")
+	fset.BoolVar(&c.private, "private", false, "make the repo created with -github private")
+	fset.StringVar(&c.gitignore, "gitignore", defaultGitignore, "language of the .gitignore to add, or none")
+	fset.BoolVar(&c.dryRun, "dryrun", false, "print actions instead of executing them")
+	fset.StringVar(&c.owner, "owner", "", "github user or org the repo belongs to, for the module path and origin url")
+}
 
-func (c newCmd) Name() string                { return "new" }
-func (c newCmd) Synopsis() string            { return "create a new repository" }
-func (c newCmd) Usage() string               { return "repos new [repo-name]\n" }
-func (c newCmd) SetFlags(fset *flag.FlagSet) {}
 func (c newCmd) Execute(ctx context.Context, fset *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	conf, err := testRepoConfig(fset, &c.testDir, &c.prefix, &c.nameTpl)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "repos new:", err)
+		return subcommands.ExitFailure
+	}
+	set := setFlags(fset)
+	if !set["hook"] && conf.NewHook != "" {
+		c.hook = conf.NewHook
+	}
+	if !set["template-dir"] && conf.TemplateDir != "" {
+		c.templateDir = conf.TemplateDir
+	}
+
+	switch c.remoteStyle {
+	case "alias", "ssh", "https":
+	default:
+		fmt.Fprintln(os.Stderr, "repos new: unknown remote style:", c.remoteStyle)
+		return subcommands.ExitUsageError
+	}
+	if c.license != "none" {
+		if _, err := builtinTemplate("license", c.license); err != nil {
+			fmt.Fprintln(os.Stderr, "repos new:", err)
+			return subcommands.ExitUsageError
+		}
+	}
+	if c.gitignore != "none" {
+		if _, err := builtinTemplate("gitignore", c.gitignore); err != nil {
+			fmt.Fprintln(os.Stderr, "repos new:", err)
+			return subcommands.ExitUsageError
+		}
+	}
+	if !validName(c.prefix) {
+		fmt.Fprintln(os.Stderr, "repos new: invalid prefix:", c.prefix)
+		return subcommands.ExitUsageError
+	}
+	if c.owner != "" && !validName(c.owner) {
+		fmt.Fprintln(os.Stderr, "repos new: invalid owner:", c.owner)
+		return subcommands.ExitUsageError
+	}
+
 	var base, name string
+	var ctr int
 	switch fset.NArg() {
 	case 0:
-		var err error
-		name, err = newTestrepoVersion()
+		base, err = testDir(c.testDir)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "repos new: get testrepo version:", err)
+			fmt.Fprintln(os.Stderr, "repos new:", err)
 			return subcommands.ExitFailure
 		}
 
-		base, err = os.UserHomeDir()
+		name, ctr, err = newTestrepoName(c.nameTpl, c.prefix, base)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "repos new: get home dir:", err)
+			fmt.Fprintln(os.Stderr, "repos new: get testrepo version:", err)
 			return subcommands.ExitFailure
 		}
-		base = filepath.Join(base, "tmp")
 
 	case 1:
 		name = fset.Arg(0)
 
-		var err error
 		base, err = os.Getwd()
 		if err != nil {
 			fmt.Fprintln(os.Stderr, "repos new: get current dir:", err)
@@ -58,97 +188,511 @@ func (c newCmd) Execute(ctx context.Context, fset *flag.FlagSet, _ ...any) subco
 		return subcommands.ExitUsageError
 	}
 
-	err := c.run(ctx, base, name)
+	err = c.run(ctx, base, name)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "repos new:", err)
 		return subcommands.ExitFailure
 	}
+	if ctr > 0 && !c.dryRun {
+		// only use up the number once the repo exists
+		err = saveTestrepoVersion(c.prefix, ctr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "repos new:", err)
+			return subcommands.ExitFailure
+		}
+	}
 	return subcommands.ExitSuccess
 }
 
-func (c newCmd) run(ctx context.Context, base, name string) error {
+func (c newCmd) run(ctx context.Context, base, name string) (err error) {
 	fp := filepath.Join(base, name)
-	err := os.MkdirAll(fp, 0o755)
-	if err != nil {
-		return fmt.Errorf("new: mkdir %s: %w", fp, err)
+	if c.worktree {
+		fp = filepath.Join(fp, "default")
+	}
+	// an empty dir, as left by mkdir, is fine to set up in
+	if entries, err := os.ReadDir(fp); err == nil && len(entries) > 0 {
+		return fmt.Errorf("new: repo already exists: %s", fp)
 	}
 
-	cmd := exec.Command("go", "mod", "init", "go.seankhliao.com/"+name)
+	// on failure, remove the topmost dir we created,
+	// a pre-existing dir is left alone,
+	// and point out a github repo that was already created
+	var created, ghRepo string
+	for _, dir := range []string{filepath.Join(base, name), fp} {
+		if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+			created = dir
+			break
+		}
+	}
+	defer func() {
+		if err != nil && created != "" && !c.dryRun {
+			if rmErr := os.RemoveAll(created); rmErr != nil {
+				err = errors.Join(err, fmt.Errorf("new: clean up %s: %w", created, rmErr))
+			}
+		}
+		if err != nil && ghRepo != "" {
+			err = errors.Join(err, fmt.Errorf("new: github repo %s was created, delete it if it isn't wanted", ghRepo))
+		}
+	}()
+
+	if c.dryRun {
+		logf("mkdir -p %s\n", fp)
+	} else {
+		err = os.MkdirAll(fp, 0o755)
+		if err != nil {
+			return fmt.Errorf("new: mkdir %s: %w", fp, err)
+		}
+	}
+
+	module := envOr(ModulePrefixEnv, defaultModulePrefix) + name
+	if c.owner != "" {
+		module = "github.com/" + c.owner + "/" + name
+	}
+	cmd := exec.Command("go", "mod", "init", module)
 	cmd.Dir = fp
-	out, err := cmd.CombinedOutput()
+	out, err := c.runCmd(cmd)
 	if err != nil {
-		return fmt.Errorf("new: go mod init: %w\n%s", err, out)
+		return withKind(ErrGoMod, fmt.Errorf("new: go mod init: %w\n%s", err, out))
 	}
 
 	cmd = exec.Command("git", "init")
 	cmd.Dir = fp
-	out, err = cmd.CombinedOutput()
+	out, err = c.runCmd(cmd)
 	if err != nil {
-		return fmt.Errorf("new: git init: %w\n%s", err, out)
+		return withKind(ErrGit, fmt.Errorf("new: git init: %w\n%s", err, out))
 	}
 
 	cmd = exec.Command("git", "commit", "--allow-empty", "-m", "root-commit")
 	cmd.Dir = fp
-	out, err = cmd.CombinedOutput()
+	out, err = c.runCmd(cmd)
 	if err != nil {
-		return fmt.Errorf("new: git commit: %w\n%s", err, out)
+		return withKind(ErrGit, fmt.Errorf("new: git commit: %w\n%s", err, out))
 	}
 
-	cmd = exec.Command("git", "remote", "add", "origin", "s:"+name)
+	remote := c.remoteURL(name)
+	cmd = exec.Command("git", "remote", "add", "origin", remote)
 	cmd.Dir = fp
-	out, err = cmd.CombinedOutput()
+	out, err = c.runCmd(cmd)
 	if err != nil {
-		return fmt.Errorf("new: git remote add: %w\n%s", err, out)
+		return withKind(ErrGit, fmt.Errorf("new: git remote add: %w\n%s", err, out))
+	}
+
+	// templates are loaded and checked against the planned remote now,
+	// so a broken template doesn't leave a github repo behind,
+	// and written once the real remote is known
+	type file struct {
+		name, kind string
+		t          *template.Template
+	}
+	var files []file
+
+	if c.license != "none" {
+		builtin, err := builtinTemplate("license", c.license)
+		if err != nil {
+			return err
+		}
+		name := "license-" + c.license + ".tpl"
+		if c.license == defaultLicense {
+			name = "license.tpl"
+		}
+		license, err := loadTemplate(c.templateDir, name, builtin)
+		if err != nil {
+			return err
+		}
+		files = append(files, file{"LICENSE", "license", license})
 	}
 
-	lf := filepath.Join(fp, "LICENSE")
-	f, err := os.Create(lf)
+	if c.gitignore != "none" {
+		builtin, err := builtinTemplate("gitignore", c.gitignore)
+		if err != nil {
+			return err
+		}
+		gitignore, err := loadTemplate(c.templateDir, "gitignore-"+c.gitignore+".tpl", builtin)
+		if err != nil {
+			return err
+		}
+		files = append(files, file{".gitignore", "gitignore", gitignore})
+	}
+
+	readme, err := loadTemplate(c.templateDir, "readme.tpl", readmeTpl)
 	if err != nil {
-		return fmt.Errorf("new: create %s: %w", lf, err)
+		return err
 	}
-	defer f.Close()
-	err = licenseTpl.Execute(f, map[string]string{
-		"Date": time.Now().Format("2006"),
-	})
+	files = append(files, file{"README.md", "readme", readme})
+
+	data := templateData(name, module, remote, c.githubOwner())
+	for _, f := range files {
+		err = f.t.Execute(io.Discard, data)
+		if err != nil {
+			return withKind(ErrTemplate, fmt.Errorf("new: render %s: %w", f.kind, err))
+		}
+	}
+
+	// create the github repo once everything local has been checked,
+	// it isn't removed on failure so it is reported instead
+	if c.github {
+		remote = "URL"
+		if c.dryRun {
+			logf("create github repo %s\n", name)
+		} else {
+			remote, err = c.createGitHubRepo(ctx, name)
+			if err != nil {
+				return err
+			}
+			ghRepo = remote
+		}
+		cmd = exec.Command("git", "remote", "set-url", "origin", remote)
+		cmd.Dir = fp
+		out, err = c.runCmd(cmd)
+		if err != nil {
+			return withKind(ErrGit, fmt.Errorf("new: git remote set-url: %w\n%s", err, out))
+		}
+		data["Remote"] = remote
+	}
+
+	for _, f := range files {
+		err = c.writeTemplate(filepath.Join(fp, f.name), f.kind, f.t, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the repo is fully set up, a failing hook still fails the command
+	// but leaves the repo, and its github remote, in place
+	created, ghRepo = "", ""
+
+	if c.hook != "" {
+		if c.dryRun {
+			logf("%s$ sh -c %q\n", fp, c.hook)
+		} else {
+			err = runHook(c.hook, name, fp)
+			if err != nil {
+				return fmt.Errorf("new: hook: %w", err)
+			}
+		}
+	}
+
+	if !c.dryRun {
+		fmt.Println("cd", fp)
+	}
+	return nil
+}
+
+// templateData is passed to the readme, license and gitignore templates.
+func templateData(name, module, remote, owner string) map[string]string {
+	year := time.Now().Format("2006")
+	return map[string]string{
+		"Name":   name,
+		"Module": module,
+		"Remote": remote,
+		"Owner":  owner,
+		"Year":   year,
+		"Date":   year, // the license copyright year
+		"Author": gitConfig("user.name"),
+		"Email":  gitConfig("user.email"),
+	}
+}
+
+// gitConfig returns the value of key in the global git config,
+// or empty if unset.
+func gitConfig(key string) string {
+	out, err := exec.Command("git", "config", "--get", key).Output()
 	if err != nil {
-		return fmt.Errorf("new: render license: %w", err)
+		return ""
+	}
+	return string(bytes.TrimSpace(out))
+}
+
+// runCmd runs cmd, with -dryrun only printing it.
+func (c newCmd) runCmd(cmd *exec.Cmd) ([]byte, error) {
+	if c.dryRun {
+		logf("%s$ %s\n", cmd.Dir, strings.Join(cmd.Args, " "))
+		return nil, nil
 	}
+	return combinedOutput(cmd)
+}
 
-	lf = filepath.Join(fp, "README.md")
-	f, err = os.Create(lf)
+// writeTemplate renders t with data to fp,
+// with -dryrun it is only rendered to check it works.
+func (c newCmd) writeTemplate(fp, kind string, t *template.Template, data map[string]string) error {
+	if c.dryRun {
+		logf("write %s\n", fp)
+		err := t.Execute(io.Discard, data)
+		if err != nil {
+			return withKind(ErrTemplate, fmt.Errorf("new: render %s: %w", kind, err))
+		}
+		return nil
+	}
+	f, err := os.Create(fp)
 	if err != nil {
-		return fmt.Errorf("new: create %s: %w", lf, err)
+		return fmt.Errorf("new: create %s: %w", fp, err)
 	}
 	defer f.Close()
-	err = readmeTpl.Execute(f, map[string]string{
-		"Name": name,
+	err = t.Execute(f, data)
+	if err != nil {
+		return withKind(ErrTemplate, fmt.Errorf("new: render %s: %w", kind, err))
+	}
+	return nil
+}
+
+// createGitHubRepo creates an empty repo for the authenticated user,
+// returning its clone url.
+func (c newCmd) createGitHubRepo(ctx context.Context, name string) (string, error) {
+	client, err := newGitHubClient(ctx, githubApp{}, os.Getenv(GithubBaseURLEnv))
+	if err != nil {
+		return "", err
+	}
+	// an empty org is the authenticated user
+	repo, _, err := client.Repositories.Create(ctx, c.owner, &github.Repository{
+		Name:    github.String(name),
+		Private: github.Bool(c.private),
 	})
 	if err != nil {
-		return fmt.Errorf("new tmp: render readme: %w", err)
+		return "", withKind(ErrGitHubAPI, fmt.Errorf("new: create github repo: %w", err))
 	}
+	if c.remoteStyle == "https" {
+		return repo.GetCloneURL(), nil
+	}
+	return repo.GetSSHURL(), nil
+}
 
-	fmt.Println("cd", fp)
-	return nil
+// runHook runs the -hook command in dir,
+// sending its output to stderr as stdout is for the shell wrapper.
+func runHook(command, name, dir string) error {
+	cmd := exec.Command("sh", "-c", command, "sh", name, dir)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"REPOS_NAME="+name,
+		"REPOS_PATH="+dir,
+	)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
 }
 
-func newTestrepoVersion() (string, error) {
+// builtinTemplate returns the embedded template of kind (license or gitignore) for id,
+// an SPDX license id or a language.
+func builtinTemplate(kind, id string) (*template.Template, error) {
+	dir := "template/" + kind + "s"
+	entries, err := builtinFS.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var known []string
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".tpl")
+		if name != id {
+			known = append(known, name)
+			continue
+		}
+		b, err := builtinFS.ReadFile(dir + "/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		t, err := template.New(kind).Parse(string(b))
+		if err != nil {
+			return nil, withKind(ErrTemplate, fmt.Errorf("parse builtin %s %s: %w", kind, id, err))
+		}
+		return t, nil
+	}
+	return nil, fmt.Errorf("unknown %s %q, known: %s, none", kind, id, strings.Join(known, ", "))
+}
+
+// testDir is where test repos are kept, dir or ~/tmp if it is empty.
+func testDir(dir string) (string, error) {
+	if dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("get home dir: %w", err)
+	}
+	return filepath.Join(home, defaultTestDir), nil
+}
+
+// loadTemplate reads name from dir,
+// falling back to the builtin template if dir is unset or doesn't have it.
+func loadTemplate(dir, name string, builtin *template.Template) (*template.Template, error) {
+	if dir == "" {
+		return builtin, nil
+	}
+	fp := filepath.Join(dir, name)
+	b, err := os.ReadFile(fp)
+	if errors.Is(err, fs.ErrNotExist) {
+		return builtin, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("new: read template %s: %w", fp, err)
+	}
+	t, err := template.New(name).Parse(string(b))
+	if err != nil {
+		return nil, withKind(ErrTemplate, fmt.Errorf("new: parse template %s: %w", fp, err))
+	}
+	return t, nil
+}
+
+func (c newCmd) remoteURL(name string) string {
+	owner := c.githubOwner()
+	switch {
+	case c.remoteStyle == "https":
+		return "https://github.com/" + owner + "/" + name
+	case c.remoteStyle == "ssh" || c.owner != "":
+		// the alias only covers the default owner
+		return "git@github.com:" + owner + "/" + name + ".git"
+	default:
+		return envOr(RemoteAliasEnv, defaultRemoteAlias) + name
+	}
+}
+
+// githubOwner is the github user or org the repo is under.
+func (c newCmd) githubOwner() string {
+	if c.owner != "" {
+		return c.owner
+	}
+	return envOr(GithubOwnerEnv, defaultGithubOwner)
+}
+
+// envOr returns the value of the environment variable key,
+// or def if it is unset or empty.
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func validName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, `/\`) && name != "." && name != ".."
+}
+
+// nameData is passed to the test repo name template.
+type nameData struct {
+	Prefix  string
+	Counter int
+	Date    string
+	User    string
+}
+
+func parseNameTemplate(tpl string) (*template.Template, error) {
+	t, err := template.New("name").Parse(tpl)
+	if err != nil {
+		return nil, withKind(ErrTemplate, fmt.Errorf("parse name template: %w", err))
+	}
+	return t, nil
+}
+
+func renderName(t *template.Template, data nameData) (string, error) {
+	var buf strings.Builder
+	err := t.Execute(&buf, data)
+	if err != nil {
+		return "", withKind(ErrTemplate, fmt.Errorf("render name template: %w", err))
+	}
+	name := buf.String()
+	if !validName(name) {
+		return "", fmt.Errorf("invalid repo name %q from name template", name)
+	}
+	return name, nil
+}
+
+// namePrefix is the part of names from tpl that doesn't vary,
+// found by rendering it with data differing from the first character.
+func namePrefix(tpl, prefix string) (string, error) {
+	t, err := parseNameTemplate(tpl)
+	if err != nil {
+		return "", err
+	}
+	a, err := renderName(t, nameData{prefix, 1, "0000-01-01", "a"})
+	if err != nil {
+		return "", err
+	}
+	b, err := renderName(t, nameData{prefix, 99999, "9999-12-31", "b"})
+	if err != nil {
+		return "", err
+	}
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return a[:i], nil
+}
+
+// newTestrepoName renders the name for a new test repo in base
+// with the next counter for prefix,
+// which is saved with saveTestrepoVersion once the repo is created.
+// Counters whose dir already exists, such as from a lost counter file,
+// are skipped over.
+func newTestrepoName(tpl, prefix, base string) (string, int, error) {
+	t, err := parseNameTemplate(tpl)
+	if err != nil {
+		return "", 0, err
+	}
+	ctr, err := nextTestrepoVersion(prefix)
+	if err != nil {
+		return "", 0, err
+	}
+	data := nameData{
+		Prefix:  prefix,
+		Counter: ctr,
+		Date:    time.Now().Format("2006-01-02"),
+		User:    currentUser(),
+	}
+	var prev string
+	for {
+		name, err := renderName(t, data)
+		if err != nil {
+			return "", 0, err
+		}
+		// a template without the counter renders the same name every time,
+		// leave it to run to report the existing dir
+		if _, err := os.Lstat(filepath.Join(base, name)); errors.Is(err, fs.ErrNotExist) || name == prev {
+			return name, data.Counter, nil
+		}
+		debugf("%s exists, skipping counter %d\n", name, data.Counter)
+		prev = name
+		data.Counter++
+	}
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// versionFile holds the last used counter for prefix,
+// each prefix has its own counter file.
+func versionFile(prefix string) (string, error) {
 	cacheDir, err := os.UserCacheDir()
 	if err != nil {
 		return "", fmt.Errorf("get cache dir: %w", err)
 	}
-	vf := filepath.Join(cacheDir, versionFile)
+	return filepath.Join(cacheDir, prefix+versionSuffix), nil
+}
+
+// nextTestrepoVersion returns the counter after the last used one for prefix.
+func nextTestrepoVersion(prefix string) (int, error) {
+	vf, err := versionFile(prefix)
+	if err != nil {
+		return 0, err
+	}
 	b, err := os.ReadFile(vf)
 	if err != nil && !errors.Is(err, fs.ErrNotExist) {
-		return "", fmt.Errorf("read %s: %w", vf, err)
+		return 0, fmt.Errorf("read %s: %w", vf, err)
 	}
 	ctr, _ := strconv.Atoi(string(b))
-	ctr++
+	return ctr + 1, nil
+}
 
+// saveTestrepoVersion records ctr as the last used counter for prefix.
+func saveTestrepoVersion(prefix string, ctr int) error {
+	vf, err := versionFile(prefix)
+	if err != nil {
+		return err
+	}
 	err = os.WriteFile(vf, []byte(strconv.Itoa(ctr)), 0o644)
 	if err != nil {
-		return "", fmt.Errorf("write %s: %w", vf, err)
+		return fmt.Errorf("write %s: %w", vf, err)
 	}
-
-	name := fmt.Sprintf("testrepo%04d", ctr)
-	return name, nil
+	return nil
 }
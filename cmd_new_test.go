@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestNamePrefix(t *testing.T) {
+	tests := []struct {
+		name    string
+		tpl     string
+		prefix  string
+		want    string
+		wantErr bool
+	}{
+		{"default", defaultNameTemplate, "testrepo", "testrepo", false},
+		{"counter first", `{{.Counter}}-{{.Prefix}}`, "testrepo", "", false},
+		{"date", `{{.Prefix}}-{{.Date}}-{{.Counter}}`, "tr", "tr-", false},
+		{"user", `{{.User}}-{{.Prefix}}`, "tr", "", false},
+		{"fixed text", `scratch-{{.Counter}}`, "tr", "scratch-", false},
+		{"bad template", `{{.Prefix`, "tr", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := namePrefix(tt.tpl, tt.prefix)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("namePrefix() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("namePrefix() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
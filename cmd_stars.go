@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/google/go-github/v48/github"
+	"github.com/google/subcommands"
+)
+
+const starsDir = "stars"
+
+type starsCmd struct {
+	dryRun   bool
+	prune    bool
+	worktree bool
+	force    bool
+	byOwner  bool
+}
+
+func (c starsCmd) Name() string     { return "import-gh-stars" }
+func (c starsCmd) Synopsis() string { return "clone repositories starred on github into stars/" }
+func (c starsCmd) Usage() string {
+	return `repos import-gh-stars [-dryrun] [-prune] [-worktree] [-force] [-group-by-owner]
+
+Lists the repositories starred by the GH_TOKEN user.
+-group-by-owner nests repos as owner/repo, so stars of the same name from different owners don't collide.
+`
+}
+
+func (c *starsCmd) SetFlags(fset *flag.FlagSet) {
+	fset.BoolVar(&c.dryRun, "dryrun", false, "print actions instead of executing them")
+	fset.BoolVar(&c.prune, "prune", false, "prune repositories no longer starred")
+	fset.BoolVar(&c.worktree, "worktree", false, "nest checkouts under repo/default")
+	fset.BoolVar(&c.force, "force", false, "ignore the lock held by another sync")
+	fset.BoolVar(&c.byOwner, "group-by-owner", false, "clone into owner/repo subdirectories")
+}
+
+func (c starsCmd) Execute(ctx context.Context, fset *flag.FlagSet, args ...any) subcommands.ExitStatus {
+	if fset.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "repos import-gh-stars: unexpected args:", args)
+		return subcommands.ExitUsageError
+	}
+
+	// cancel in flight git commands on interrupt, so the lock is released
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	release, err := acquireLock(starsDir, c.force)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "repos import-gh-stars:", err)
+		return subcommands.ExitFailure
+	}
+	defer release()
+
+	err = c.run(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "repos import-gh-stars:", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+func (c starsCmd) run(ctx context.Context) error {
+	client, err := newGitHubClient(ctx, githubApp{}, os.Getenv(GithubBaseURLEnv))
+	if err != nil {
+		return err
+	}
+
+	// reuse the syncgh clone and prune logic
+	gh := syncGHCmd{
+		archived:  true,
+		forks:     true,
+		dryRun:    c.dryRun,
+		prune:     c.prune,
+		worktree:  c.worktree,
+		byOwner:   c.byOwner,
+		parallel:  5,
+		scanDir:   starsDir,
+		outputDir: starsDir,
+	}
+
+	allReposM := make(map[string]remoteRepo)
+	for page := 1; true; page++ {
+		starred, res, err := client.Activity.ListStarred(ctx, "", &github.ActivityListStarredOptions{
+			ListOptions: github.ListOptions{
+				Page:    page,
+				PerPage: 100,
+			},
+		})
+		if err != nil {
+			return withKind(ErrGitHubAPI, fmt.Errorf("list starred repos page %d: %w", page, err))
+		}
+		repos := make([]*github.Repository, 0, len(starred))
+		for _, s := range starred {
+			repos = append(repos, s.Repository)
+		}
+		err = gh.addRepos(allReposM, repos)
+		if err != nil {
+			return err
+		}
+		if page >= res.LastPage {
+			break
+		}
+	}
+
+	return gh.reconcile(ctx, allReposM)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/subcommands"
+)
+
+type statusCmd struct {
+	dir      string
+	parallel int
+}
+
+func (c statusCmd) Name() string     { return "status" }
+func (c statusCmd) Synopsis() string { return "show local changes in repositories without syncing" }
+func (c statusCmd) Usage() string    { return "repos status [-dir=PATH] [-parallel=N]\n" }
+func (c *statusCmd) SetFlags(fset *flag.FlagSet) {
+	fset.StringVar(&c.dir, "dir", envOr(RootEnv, "."), "directory of repos to check, defaults to $"+RootEnv+" or the current directory")
+	fset.IntVar(&c.parallel, "parallel", 5, "parallel status checks to run")
+}
+
+func (c statusCmd) Execute(ctx context.Context, fset *flag.FlagSet, args ...any) subcommands.ExitStatus {
+	if fset.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "repos status: unexpected args:", args)
+		return subcommands.ExitUsageError
+	}
+
+	err := c.run(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "repos status:", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+type statusResult struct {
+	dir           string
+	err           error
+	changed       int  // uncommitted changes, including untracked files
+	ahead         int  // commits not on the upstream branch
+	noUpstream    bool // the branch has no upstream to compare against
+	branch        string
+	defaultBranch string
+}
+
+func (c statusCmd) run(ctx context.Context) error {
+	repoDirs, err := listRepoDirs(c.dir, false)
+	if err != nil {
+		return err
+	}
+
+	results := make([]statusResult, len(repoDirs))
+	forEachParallel(len(repoDirs), c.parallel, func(i int) {
+		if ctx.Err() != nil {
+			results[i] = statusResult{dir: filepath.Base(repoDirs[i]), err: ctx.Err()}
+			return
+		}
+		results[i] = repoStatus(ctx, repoDirs[i])
+	})
+
+	for i, res := range results {
+		msg := fmt.Sprintf("%4d %s: ", i+1, res.dir)
+		if res.err != nil {
+			fmt.Fprintln(os.Stderr, msg+res.err.Error())
+			continue
+		}
+		var notes []string
+		if res.changed > 0 {
+			notes = append(notes, strconv.Itoa(res.changed)+" changed")
+		}
+		if res.noUpstream {
+			notes = append(notes, "no upstream")
+		} else if res.ahead > 0 {
+			notes = append(notes, strconv.Itoa(res.ahead)+" unpushed")
+		}
+		if res.defaultBranch != "" && res.branch != res.defaultBranch {
+			notes = append(notes, "on "+res.branch+" not "+res.defaultBranch)
+		}
+		if len(notes) == 0 {
+			notes = append(notes, "clean")
+		}
+		fmt.Fprintln(os.Stderr, msg+strings.Join(notes, ", "))
+	}
+	return nil
+}
+
+// repoStatus inspects the checkout for dir using only local state.
+func repoStatus(ctx context.Context, dir string) statusResult {
+	res := statusResult{
+		dir: filepath.Base(dir),
+	}
+	wd, err := gitWorkDir(ctx, dir)
+	if err != nil {
+		res.err = err
+		return res
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "status", "--porcelain")
+	cmd.Dir = wd
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		res.err = withKind(ErrGit, fmt.Errorf("git status: %w\n%s", err, out))
+		return res
+	}
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		if len(line) > 0 {
+			res.changed++
+		}
+	}
+
+	res.branch, err = currentBranch(ctx, wd)
+	if err != nil {
+		res.err = err
+		return res
+	}
+
+	// no remote default branch is fine, there's just nothing to compare to
+	cmd = exec.CommandContext(ctx, "git", "rev-parse", "--abbrev-ref", "origin/HEAD")
+	cmd.Dir = wd
+	out, err = cmd.Output()
+	if err == nil {
+		res.defaultBranch = path.Base(string(bytes.TrimSpace(out)))
+	}
+
+	cmd = exec.CommandContext(ctx, "git", "rev-list", "--count", "@{u}..HEAD")
+	cmd.Dir = wd
+	out, err = cmd.Output()
+	if err != nil {
+		res.noUpstream = true
+		return res
+	}
+	res.ahead, err = strconv.Atoi(string(bytes.TrimSpace(out)))
+	if err != nil {
+		res.err = fmt.Errorf("parse unpushed count %q: %w", bytes.TrimSpace(out), err)
+	}
+	return res
+}
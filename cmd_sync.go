@@ -3,23 +3,30 @@ package main
 import (
 	"bytes"
 	"context"
-	_ "embed"
+	"embed"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"text/template"
+	"time"
 
 	"github.com/google/subcommands"
 )
 
 var (
-	//go:embed template/license.tpl
-	licenseRaw string
-	licenseTpl = template.Must(template.New("license").Parse(licenseRaw))
+	//go:embed template/licenses template/gitignores
+	builtinFS embed.FS
 
 	//go:embed template/readme.tpl
 	readmeRaw string
@@ -27,14 +34,98 @@ var (
 )
 
 type syncCmd struct {
+	dir      string
 	parallel int
+	force    bool
+	probe    bool
+	bench    bool
+
+	skipNoUpstream bool
+	singleBranch   bool
+	push           bool
+	notes          bool
+	onlyBehind     bool
+	maxRewrite     int
+	allowRewrite   bool
+	continueFrom   string
+	branches       []branchRule
+	deepen         bool
+	skipDirty      bool
+	sortWorkers    bool
+	json           bool
+	keepBranch     bool
+	groupByOwner   bool
+	timeout        time.Duration
+	skip           []string
+	gc             bool
+	submodules     bool
+	noPruneTags    bool
+	noForceFetch   bool
+	perHost        int
+
+	hosts *hostLimiter
+}
+
+// branchRule overrides the branch synced for repos matching pattern.
+type branchRule struct {
+	pattern string
+	branch  string
 }
 
 func (c syncCmd) Name() string     { return "sync" }
 func (c syncCmd) Synopsis() string { return "sync repositories with upstream" }
-func (c syncCmd) Usage() string    { return "repos sync [-parallel=N]\n" }
+func (c syncCmd) Usage() string {
+	return `repos sync [-dir=PATH] [-parallel=N] [-force] [-probe] [-bench] [-skip-no-upstream] [-single-branch] [-push] [-notes] [-only-behind] [-max-rewrite=N] [-allow-rewrite] [-continue-from=REPO] [-branch=GLOB=BRANCH]... [-deepen] [-skip-dirty] [-sort-workers] [-json] [-keep-branch] [-group-by-owner] [-timeout=DURATION] [-skip=GLOB]... [-gc] [-submodules] [-no-prune-tags] [-no-force-fetch] [-concurrency-per-host=N] [-q] [-v]
+
+Symlinks to directories are synced as repos,
+unless they point back into -dir.
+`
+}
 func (c *syncCmd) SetFlags(fset *flag.FlagSet) {
+	fset.StringVar(&c.dir, "dir", envOr(RootEnv, "."), "directory of repos to sync, defaults to $"+RootEnv+" or the current directory")
 	fset.IntVar(&c.parallel, "parallel", 5, "parallel syncs to run")
+	fset.BoolVar(&c.force, "force", false, "ignore the lock held by another sync")
+	fset.BoolVar(&c.probe, "probe", false, "check remotes are reachable before syncing")
+	fset.BoolVar(&c.bench, "bench", false, "time fetches at varying parallelism without changing repos")
+	fset.BoolVar(&c.skipNoUpstream, "skip-no-upstream", false, "skip repos without a remote to sync from")
+	fset.BoolVar(&c.singleBranch, "single-branch", false, "only fetch the default branch")
+	fset.BoolVar(&c.push, "push", false, "push local commits on the default branch")
+	fset.BoolVar(&c.notes, "notes", false, "also fetch git notes")
+	fset.BoolVar(&c.onlyBehind, "only-behind", false, "only print repos that were updated")
+	fset.IntVar(&c.maxRewrite, "max-rewrite", 0, "commits of a diverged HEAD that may be replaced by a rewritten upstream")
+	fset.BoolVar(&c.allowRewrite, "allow-rewrite", false, "follow rewritten upstream history regardless of -max-rewrite")
+	fset.StringVar(&c.continueFrom, "continue-from", "", "skip repos sorting before this name, to resume an interrupted sync")
+	fset.Func("branch", "GLOB=BRANCH to sync BRANCH instead of origin/HEAD for matching repos, repeatable, git config repos.branch takes precedence", func(s string) error {
+		pattern, branch, ok := strings.Cut(s, "=")
+		if !ok || pattern == "" || branch == "" {
+			return fmt.Errorf("expected GLOB=BRANCH")
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad pattern %q: %w", pattern, err)
+		}
+		c.branches = append(c.branches, branchRule{pattern, branch})
+		return nil
+	})
+	fset.BoolVar(&c.deepen, "deepen", false, "fetch full history for shallow clones")
+	fset.BoolVar(&c.skipDirty, "skip-dirty", false, "only fetch repos with uncommitted changes, skipping the autostash merge")
+	fset.BoolVar(&c.sortWorkers, "sort-workers", false, "sync the largest repos first so they don't hold up the end of the run")
+	fset.BoolVar(&c.json, "json", false, "print json to stdout, with the error kind of failed repos")
+	logFlags(fset)
+	fset.DurationVar(&c.timeout, "timeout", 0, "time limit for syncing each repo, 0 for none")
+	fset.BoolVar(&c.groupByOwner, "group-by-owner", false, "repos are nested under owner directories, as cloned by syncgh -group-by-owner")
+	fset.Func("skip", "glob pattern against repo name to leave alone, repeatable, patterns are also read from "+ignoreFileName+" in -dir", func(s string) error {
+		if _, err := filepath.Match(s, ""); err != nil {
+			return fmt.Errorf("bad pattern %q: %w", s, err)
+		}
+		c.skip = append(c.skip, s)
+		return nil
+	})
+	fset.BoolVar(&c.gc, "gc", false, "run git gc --auto after syncing each repo")
+	fset.BoolVar(&c.submodules, "submodules", false, "update submodules after merging")
+	fset.BoolVar(&c.noPruneTags, "no-prune-tags", false, "keep local tags that don't exist upstream")
+	fset.BoolVar(&c.noForceFetch, "no-force-fetch", false, "don't overwrite local tags and branches that changed upstream")
+	fset.IntVar(&c.perHost, "concurrency-per-host", 0, "max concurrent fetches and pushes against each remote host, 0 for no limit beyond -parallel")
+	fset.BoolVar(&c.keepBranch, "keep-branch", false, "only fetch repos on another branch or a detached HEAD instead of switching to the default branch")
 }
 
 func (c syncCmd) Execute(ctx context.Context, fset *flag.FlagSet, args ...any) subcommands.ExitStatus {
@@ -43,7 +134,22 @@ func (c syncCmd) Execute(ctx context.Context, fset *flag.FlagSet, args ...any) s
 		return subcommands.ExitUsageError
 	}
 
-	err := c.run(ctx)
+	// cancel in flight git commands on interrupt
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	release, err := acquireLock(c.dir, c.force)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "repos sync:", err)
+		return subcommands.ExitFailure
+	}
+	defer release()
+
+	if c.bench {
+		err = c.runBench(ctx)
+	} else {
+		err = c.run(ctx)
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "repos sync:", err)
 		return subcommands.ExitFailure
@@ -52,17 +158,76 @@ func (c syncCmd) Execute(ctx context.Context, fset *flag.FlagSet, args ...any) s
 }
 
 func (c syncCmd) run(ctx context.Context) error {
-	baseDir := "."
-
-	des, err := os.ReadDir(baseDir)
+	repoDirs, err := c.repoDirs()
 	if err != nil {
-		return fmt.Errorf("sync: read %s: %w", baseDir, err)
+		return err
 	}
-	dirs := make(chan string, len(des))
-	for _, de := range des {
-		if de.IsDir() {
-			dirs <- filepath.Join(baseDir, de.Name())
+	if c.continueFrom != "" {
+		var rest []string
+		for _, dir := range repoDirs {
+			if c.repoName(dir) >= c.continueFrom {
+				rest = append(rest, dir)
+			}
 		}
+		repoDirs = rest
+	}
+
+	var i, total, unchanged int
+	var failed, updated []syncResult
+	var jsonErr error
+	enc := json.NewEncoder(os.Stdout)
+	report := func(res syncResult) {
+		total++
+		switch {
+		case res.err != nil:
+			failed = append(failed, res)
+		case res.skip == "" && res.oldRef != res.newRef:
+			updated = append(updated, res)
+		default:
+			unchanged++
+		}
+		if c.json {
+			if jsonErr == nil {
+				jsonErr = enc.Encode(res.jsonResult())
+			}
+			return
+		}
+		if c.onlyBehind && (res.err != nil || res.skip != "" || res.oldRef == res.newRef) {
+			return
+		}
+		i++
+		msg := fmt.Sprintf("%4d %s: ", i, res.dir)
+		if res.err != nil {
+			msg += res.err.Error()
+		} else if res.skip != "" {
+			msg += "skipped (" + res.skip + ")"
+		} else if res.oldRef == res.newRef {
+			msg += res.newRef
+		} else {
+			msg += res.oldRef + " -> " + res.newRef
+		}
+		if len(res.info) > 0 {
+			msg += " (" + strings.Join(res.info, ", ") + ")"
+		}
+		logAction(msg, res.err)
+	}
+
+	c.hosts = newHostLimiter(c.perHost)
+	if c.probe {
+		var unreachable []syncResult
+		repoDirs, unreachable = c.probeRemotes(ctx, repoDirs)
+		for _, res := range unreachable {
+			report(res)
+		}
+	}
+
+	if c.sortWorkers {
+		repoDirs = c.largestFirst(ctx, repoDirs)
+	}
+
+	dirs := make(chan string, len(repoDirs))
+	for _, dir := range repoDirs {
+		dirs <- dir
 	}
 	close(dirs)
 
@@ -70,117 +235,769 @@ func (c syncCmd) run(ctx context.Context) error {
 	var wg sync.WaitGroup
 	for i := 0; i < c.parallel; i++ {
 		wg.Add(1)
-		go syncWorker(&wg, dirs, resc)
+		go c.syncWorker(ctx, &wg, dirs, resc)
 	}
 	go func() {
 		wg.Wait()
 		close(resc)
 	}()
 
-	var i int
 	for res := range resc {
-		i++
-		msg := fmt.Sprintf("%4d %s: ", i, res.dir)
-		if res.err != nil {
-			msg += res.err.Error()
-		} else if res.oldRef == res.newRef {
-			msg += res.newRef
-		} else {
-			msg += res.oldRef + " -> " + res.newRef
+		report(res)
+	}
+	if jsonErr != nil {
+		return fmt.Errorf("write json: %w", jsonErr)
+	}
+
+	if len(updated) > 0 && !c.onlyBehind && !c.json {
+		sort.Slice(updated, func(i, j int) bool { return updated[i].dir < updated[j].dir })
+		logf("updated repos:\n")
+		for _, res := range updated {
+			logf("  %s: %s -> %s\n", res.dir, res.oldRef, res.newRef)
 		}
-		fmt.Fprintln(os.Stderr, msg)
+	}
+	if len(failed) > 0 && !c.onlyBehind && !c.json {
+		sort.Slice(failed, func(i, j int) bool { return failed[i].dir < failed[j].dir })
+		fmt.Fprintln(os.Stderr, "failed repos:")
+		for _, res := range failed {
+			reason, _, _ := strings.Cut(res.err.Error(), "\n")
+			fmt.Fprintf(os.Stderr, "  %s: %s\n", res.dir, reason)
+		}
+	}
+	if !c.json {
+		logf("%d total: %d updated, %d unchanged, %d errors\n", total, len(updated), unchanged, len(failed))
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d repos failed", len(failed), total)
 	}
 	return nil
 }
 
-type syncResult struct {
-	dir    string
-	err    error
-	oldRef string
-	newRef string
+// largestFirst orders dirs by the size of their git dir, largest first,
+// for longest processing time first scheduling.
+func (c syncCmd) largestFirst(ctx context.Context, dirs []string) []string {
+	sizes := make([]int64, len(dirs))
+	forEachParallel(len(dirs), c.parallel, func(i int) {
+		if wd, err := gitWorkDir(ctx, dirs[i]); err == nil {
+			sizes[i], _ = dirSize(filepath.Join(wd, ".git"))
+		}
+	})
+
+	order := make([]int, len(dirs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return sizes[order[i]] > sizes[order[j]]
+	})
+	sorted := make([]string, len(dirs))
+	for i, o := range order {
+		sorted[i] = dirs[o]
+	}
+	return sorted
 }
 
-func syncWorker(wg *sync.WaitGroup, in <-chan string, out chan syncResult) {
-	defer wg.Done()
-	for dir := range in {
-		out <- syncRepo(dir)
+// listRepoDirs returns the candidate repo directories under baseDir.
+func listRepoDirs(baseDir string, byOwner bool) ([]string, error) {
+	names, err := scanRepoNames(baseDir, byOwner)
+	if err != nil {
+		return nil, fmt.Errorf("sync: %w", err)
+	}
+	var repoDirs []string
+	for _, name := range names {
+		repoDirs = append(repoDirs, filepath.Join(baseDir, name))
 	}
+	return repoDirs, nil
 }
 
-func syncRepo(dir string) syncResult {
-	res := syncResult{
-		dir: filepath.Base(dir),
+// repoDirs lists the repos to sync,
+// leaving out those matching -skip or the ignore file.
+func (c syncCmd) repoDirs() ([]string, error) {
+	repoDirs, err := listRepoDirs(c.dir, c.groupByOwner)
+	if err != nil {
+		return nil, err
+	}
+	ignored, err := readIgnoreFile(c.dir)
+	if err != nil {
+		return nil, err
+	}
+	patterns := append(ignored, c.skip...)
+	if len(patterns) == 0 {
+		return repoDirs, nil
+	}
+
+	var keep []string
+dirLoop:
+	for _, dir := range repoDirs {
+		name := c.repoName(dir)
+		for _, pattern := range patterns {
+			if ok, _ := filepath.Match(pattern, name); ok {
+				debugf("skipping %s, matches %q\n", name, pattern)
+				continue dirLoop
+			}
+		}
+		keep = append(keep, dir)
+	}
+	return keep, nil
+}
+
+// repoName is dir relative to the repos root,
+// owner/repo with -group-by-owner.
+func (c syncCmd) repoName(dir string) string {
+	name, err := filepath.Rel(c.dir, dir)
+	if err != nil {
+		return filepath.Base(dir)
+	}
+	return filepath.ToSlash(name)
+}
+
+var benchParallel = []int{1, 2, 4, 8, 16}
+
+// runBench times a dry run fetch of every repo at different levels of parallelism.
+func (c syncCmd) runBench(ctx context.Context) error {
+	repoDirs, err := c.repoDirs()
+	if err != nil {
+		return err
+	}
+
+	c.hosts = newHostLimiter(c.perHost)
+	fmt.Fprintf(os.Stderr, "%8s %12s %6s\n", "parallel", "elapsed", "errors")
+	for _, parallel := range benchParallel {
+		dirs := make(chan string, len(repoDirs))
+		for _, dir := range repoDirs {
+			dirs <- dir
+		}
+		close(dirs)
+
+		var errCount atomic.Int64
+		start := time.Now()
+		var wg sync.WaitGroup
+		for i := 0; i < parallel; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for dir := range dirs {
+					if c.benchFetch(ctx, dir) != nil {
+						errCount.Add(1)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		elapsed := time.Since(start).Round(time.Millisecond)
+		fmt.Fprintf(os.Stderr, "%8d %12s %6d\n", parallel, elapsed, errCount.Load())
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (c syncCmd) benchFetch(ctx context.Context, dir string) error {
+	wd, err := gitWorkDir(ctx, dir)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "git", "fetch", "--dry-run", "--quiet", "--tags", "--jobs=10")
+	cmd.Dir = wd
+	release := c.hosts.acquireOrigin(ctx, wd)
+	defer release()
+	return cmd.Run()
+}
+
+const probeTimeout = 10 * time.Second
+
+// probeRemotes splits dirs into those with a reachable origin
+// and results for those without.
+func (c syncCmd) probeRemotes(ctx context.Context, dirs []string) ([]string, []syncResult) {
+	errs := make([]error, len(dirs))
+	forEachParallel(len(dirs), c.parallel, func(i int) {
+		errs[i] = probeRemote(ctx, c.hosts, dirs[i])
+	})
+
+	var reachable []string
+	var unreachable []syncResult
+	for i, dir := range dirs {
+		if errs[i] != nil {
+			unreachable = append(unreachable, syncResult{
+				dir: c.repoName(dir),
+				err: errs[i],
+			})
+			continue
+		}
+		reachable = append(reachable, dir)
+	}
+	return reachable, unreachable
+}
+
+func probeRemote(ctx context.Context, hosts *hostLimiter, dir string) error {
+	wd, err := gitWorkDir(ctx, dir)
+	if err != nil {
+		// leave it to syncRepo to report
+		return nil
+	}
+
+	out, err := lsRemote(ctx, hosts, wd, probeTimeout)
+	if err != nil {
+		return withKind(ErrRemoteUnreachable, fmt.Errorf("remote unreachable: %w\n%s", err, out))
+	}
+	return nil
+}
+
+// lsRemote looks up HEAD on origin without prompting for credentials.
+// The timeout starts once hosts has a slot for origin.
+func lsRemote(ctx context.Context, hosts *hostLimiter, wd string, timeout time.Duration) ([]byte, error) {
+	release := hosts.acquireOrigin(ctx, wd)
+	defer release()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--exit-code", "origin", "HEAD")
+	cmd.Dir = wd
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	return cmd.CombinedOutput()
+}
+
+// mergeUpstream fast forwards the checked out branch to its upstream,
+// pushing local commits if asked.
+func (c syncCmd) mergeUpstream(ctx context.Context, wd string, res *syncResult) error {
+	replaced, err := c.followRewrite(ctx, wd)
+	if err != nil {
+		return err
+	}
+	if replaced > 0 {
+		res.info = append(res.info, fmt.Sprintf("replaced %d rewritten commits", replaced))
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "merge", "--ff-only", "--autostash")
+	cmd.Dir = wd
+	out, err := combinedOutput(cmd)
+	if err != nil {
+		return withKind(ErrMergeConflict, fmt.Errorf("merge: %w\n%s", err, out))
+	}
+
+	if c.push {
+		cmd = exec.CommandContext(ctx, "git", "rev-list", "--count", "@{upstream}..HEAD")
+		cmd.Dir = wd
+		out, err = combinedOutput(cmd)
+		if err != nil {
+			return withKind(ErrGit, fmt.Errorf("count unpushed commits: %w\n%s", err, out))
+		}
+		if ahead := string(bytes.TrimSpace(out)); ahead != "0" {
+			cmd = exec.CommandContext(ctx, "git", "push")
+			cmd.Dir = wd
+			release := c.hosts.acquireOrigin(ctx, wd)
+			out, err = combinedOutput(cmd)
+			release()
+			if err != nil && pushDenied(out) {
+				// read only remotes are expected, the sync itself still worked
+				res.info = append(res.info, "push denied, "+ahead+" commits not pushed")
+				return nil
+			} else if err != nil {
+				return withKind(ErrGit, fmt.Errorf("push: %w\n%s", err, out))
+			}
+			res.info = append(res.info, "pushed "+ahead+" commits")
+		}
+	}
+	return nil
+}
+
+// pushDenied reports whether the output of a failed git push
+// says we don't have write access to the remote.
+func pushDenied(out []byte) bool {
+	msg := strings.ToLower(string(out))
+	for _, s := range []string{"permission denied", "permission to", "403", "access denied", "not allowed to push"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// branchOverride returns the branch to sync instead of origin/HEAD,
+// from the repo's repos.branch git config or the first matching -branch rule.
+func (c syncCmd) branchOverride(ctx context.Context, wd, name string) string {
+	cmd := exec.CommandContext(ctx, "git", "config", "--get", "repos.branch")
+	cmd.Dir = wd
+	out, err := cmd.Output()
+	if branch := string(bytes.TrimSpace(out)); err == nil && branch != "" {
+		return branch
+	}
+	for _, rule := range c.branches {
+		if ok, _ := filepath.Match(rule.pattern, name); ok {
+			return rule.branch
+		}
+	}
+	return ""
+}
+
+// followRewrite checks whether HEAD and its upstream have diverged
+// because upstream was force pushed:
+// the commit HEAD forked from, found through the upstream reflog,
+// is no longer in upstream's history.
+// Divergence from local commits is left to the merge to report.
+// Unless the number of commits only in HEAD is within -max-rewrite,
+// or -allow-rewrite is set, this is an error,
+// otherwise HEAD is reset to upstream and the replaced commit count returned.
+func (c syncCmd) followRewrite(ctx context.Context, wd string) (int, error) {
+	isAncestor := func(a, b string) (bool, error) {
+		cmd := exec.CommandContext(ctx, "git", "merge-base", "--is-ancestor", a, b)
+		cmd.Dir = wd
+		out, err := combinedOutput(cmd)
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return false, nil
+		} else if err != nil {
+			return false, withKind(ErrGit, fmt.Errorf("check ancestry: %w\n%s", err, out))
+		}
+		return true, nil
+	}
+
+	if ok, err := isAncestor("HEAD", "@{upstream}"); ok || err != nil {
+		return 0, err
+	}
+	if ok, err := isAncestor("@{upstream}", "HEAD"); ok || err != nil {
+		return 0, err
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "merge-base", "--fork-point", "@{upstream}", "HEAD")
+	cmd.Dir = wd
+	out, err := cmd.Output()
+	forkPoint := string(bytes.TrimSpace(out))
+	if err != nil || forkPoint == "" {
+		// without a reflog entry HEAD came from, it can't be told apart from local work
+		return 0, nil
+	}
+	if ok, err := isAncestor(forkPoint, "@{upstream}"); ok || err != nil {
+		return 0, err
 	}
 
+	cmd = exec.CommandContext(ctx, "git", "rev-list", "--count", "@{upstream}..HEAD")
+	cmd.Dir = wd
+	out, err = combinedOutput(cmd)
+	if err != nil {
+		return 0, withKind(ErrGit, fmt.Errorf("count diverged commits: %w\n%s", err, out))
+	}
+	count, _ := strconv.Atoi(string(bytes.TrimSpace(out)))
+	if count > c.maxRewrite && !c.allowRewrite {
+		return 0, withKind(ErrMergeConflict, fmt.Errorf("diverged from upstream, %d commits of HEAD would be replaced, use -max-rewrite or -allow-rewrite to follow upstream", count))
+	}
+
+	cmd = exec.CommandContext(ctx, "git", "reset", "--keep", "@{upstream}")
+	cmd.Dir = wd
+	out, err = combinedOutput(cmd)
+	if err != nil {
+		return 0, withKind(ErrGit, fmt.Errorf("reset to upstream: %w\n%s", err, out))
+	}
+	return count, nil
+}
+
+// fetchNotes fetches refs/notes/* from origin,
+// reporting whether any notes ref changed.
+func fetchNotes(ctx context.Context, wd string) (bool, error) {
+	listNotes := func() ([]byte, error) {
+		cmd := exec.CommandContext(ctx, "git", "for-each-ref", "refs/notes")
+		cmd.Dir = wd
+		out, err := combinedOutput(cmd)
+		if err != nil {
+			return nil, withKind(ErrGit, fmt.Errorf("list notes: %w\n%s", err, out))
+		}
+		return out, nil
+	}
+
+	before, err := listNotes()
+	if err != nil {
+		return false, err
+	}
+	cmd := exec.CommandContext(ctx, "git", "fetch", "--force", "origin", "refs/notes/*:refs/notes/*")
+	cmd.Dir = wd
+	out, err := combinedOutput(cmd)
+	if err != nil {
+		return false, withKind(ErrFetch, fmt.Errorf("fetch notes: %w\n%s", err, out))
+	}
+	after, err := listNotes()
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(before, after), nil
+}
+
+// isDirty reports whether the checkout in wd has uncommitted changes,
+// optionally counting untracked files.
+func isDirty(ctx context.Context, wd string, untracked bool) (bool, error) {
+	args := []string{"status", "--porcelain"}
+	if !untracked {
+		args = append(args, "--untracked-files=no")
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = wd
+	out, err := combinedOutput(cmd)
+	if err != nil {
+		return false, withKind(ErrGit, fmt.Errorf("git status: %w\n%s", err, out))
+	}
+	return len(bytes.TrimSpace(out)) > 0, nil
+}
+
+// currentBranch returns the branch checked out in wd,
+// or HEAD if it is detached.
+func currentBranch(ctx context.Context, wd string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--abbrev-ref", "HEAD")
+	cmd.Dir = wd
+	out, err := combinedOutput(cmd)
+	if err != nil {
+		return "", withKind(ErrGit, fmt.Errorf("get current branch: %w\n%s", err, out))
+	}
+	return string(bytes.TrimSpace(out)), nil
+}
+
+// gitWorkDir finds the checkout for a repo,
+// either nested under dir/default or dir itself.
+func gitWorkDir(ctx context.Context, dir string) (string, error) {
 	wd := filepath.Join(dir, "default")
-	gitDir := filepath.Join(wd, ".git")
-	_, err := os.Stat(gitDir)
+	_, err := os.Stat(filepath.Join(wd, ".git"))
 	if err != nil {
 		wd = dir
-		gitDir = filepath.Join(wd, ".git")
-		_, err = os.Stat(gitDir)
-		if err != nil {
-			res.err = fmt.Errorf("no git dir found")
-			return res
+		_, err = os.Stat(filepath.Join(wd, ".git"))
+		if err != nil && !isBareRepo(ctx, wd) {
+			return "", ErrNoGitDir
 		}
 	}
+	return wd, nil
+}
+
+// fetchArgs are the arguments to git for fetching from origin.
+func (c syncCmd) fetchArgs(defaultBranch string) []string {
+	args := []string{"fetch"}
+	if !c.noForceFetch {
+		args = append(args, "--force")
+	}
+	if c.singleBranch {
+		return append(args, "origin", defaultBranch)
+	}
+	args = append(args, "--tags", "--prune", "--jobs=10")
+	if !c.noPruneTags {
+		args = append(args, "--prune-tags")
+	}
+	return args
+}
 
-	cmd := exec.Command("git", "rev-parse", "--short", "HEAD")
+// updateSubmodules checks out the submodule commits recorded in HEAD,
+// if the repo has any submodules.
+func updateSubmodules(ctx context.Context, wd string) error {
+	if _, err := os.Stat(filepath.Join(wd, ".gitmodules")); err != nil {
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, "git", "submodule", "update", "--init", "--recursive")
 	cmd.Dir = wd
-	out, err := cmd.CombinedOutput()
+	out, err := combinedOutput(cmd)
 	if err != nil {
-		res.err = fmt.Errorf("get old ref: %w", err)
-		return res
+		return withKind(ErrGit, fmt.Errorf("update submodules: %w\n%s", err, out))
 	}
-	res.oldRef = string(bytes.TrimSpace(out))
+	return nil
+}
 
-	// ensure we're on the default branch
-	cmd = exec.Command("git", "rev-parse", "--abbrev-ref", "origin/HEAD")
+// hasRefs reports whether wd has any refs under prefix,
+// new repos and their remotes have none until the first push.
+func hasRefs(ctx context.Context, wd, prefix string) bool {
+	cmd := exec.CommandContext(ctx, "git", "for-each-ref", "--count=1", prefix)
 	cmd.Dir = wd
-	out, err = cmd.CombinedOutput()
+	out, err := combinedOutput(cmd)
+	// assume there are refs if we can't tell, so the original error is reported
+	return err != nil || len(bytes.TrimSpace(out)) > 0
+}
+
+// isBareRepo reports whether dir is a git dir without a working tree,
+// like a mirror clone.
+// Asking git rules out the .git dir of a working repo,
+// which has the same layout but core.bare unset.
+func isBareRepo(ctx context.Context, dir string) bool {
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--is-bare-repository", "--git-dir")
+	cmd.Dir = dir
+	out, err := cmd.Output()
 	if err != nil {
-		res.err = fmt.Errorf("get remote default branch: %w\n%s", err, out)
-		return res
+		return false
 	}
+	// --git-dir is . only for dir itself, not a dir nested in a bare repo
+	return strings.Join(strings.Fields(string(out)), " ") == "true ."
+}
+
+type syncResult struct {
+	dir    string
+	err    error
+	skip   string   // reason the repo was skipped
+	info   []string // extra notes on what was done
+	oldRef string
+	newRef string
+}
 
-	defaultBranch := path.Base(string(bytes.TrimSpace(out)))
+// syncJSON is the -json form of a syncResult.
+type syncJSON struct {
+	Dir     string `json:"dir"`
+	OldRef  string `json:"old_ref"`
+	NewRef  string `json:"new_ref"`
+	Skipped string `json:"skipped,omitempty"`
+	Err     string `json:"error,omitempty"`
+	Kind    string `json:"kind,omitempty"`
+}
 
-	cmd = exec.Command("git", "checkout", defaultBranch)
+func (r syncResult) jsonResult() syncJSON {
+	res := syncJSON{
+		Dir:     r.dir,
+		OldRef:  r.oldRef,
+		NewRef:  r.newRef,
+		Skipped: r.skip,
+	}
+	if r.err != nil {
+		res.Err = r.err.Error()
+		res.Kind = errorKind(r.err)
+	}
+	return res
+}
+
+// syncMirror updates a bare mirror, which has no working tree to merge into.
+func (c syncCmd) syncMirror(ctx context.Context, wd string, res syncResult) syncResult {
+	res.info = append(res.info, "mirror")
+	// empty mirrors have no HEAD yet, but may still gain refs on update
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--short", "HEAD")
 	cmd.Dir = wd
-	out, err = cmd.CombinedOutput()
+	out, err := cmd.Output()
+	if err == nil {
+		res.oldRef = string(bytes.TrimSpace(out))
+	}
+
+	cmd = exec.CommandContext(ctx, "git", "remote", "update", "--prune")
+	cmd.Dir = wd
+	release := c.hosts.acquireOrigin(ctx, wd)
+	out, err = combinedOutput(cmd)
+	release()
 	if err != nil {
-		res.err = fmt.Errorf("switch to default branch: %w\n%s", err, out)
+		res.err = withKind(ErrFetch, fmt.Errorf("remote update: %w\n%s", err, out))
 		return res
 	}
 
-	cmd = exec.Command("git", "fetch", "--tags", "--prune", "--prune-tags", "--force", "--jobs=10")
+	cmd = exec.CommandContext(ctx, "git", "rev-parse", "--short", "HEAD")
 	cmd.Dir = wd
-	out, err = cmd.CombinedOutput()
-	if err != nil {
-		res.err = fmt.Errorf("fetch: %w\n%s", err, out)
+	out, err = cmd.Output()
+	if err == nil {
+		res.newRef = string(bytes.TrimSpace(out))
+	} else if res.oldRef == "" {
+		res.skip = "empty repo, nothing to sync"
+	}
+	return res
+}
+
+func (c syncCmd) syncWorker(ctx context.Context, wg *sync.WaitGroup, in <-chan string, out chan syncResult) {
+	defer wg.Done()
+	for dir := range in {
+		if ctx.Err() != nil {
+			out <- syncResult{dir: c.repoName(dir), err: ctx.Err()}
+			continue
+		}
+		rctx, cancel := ctx, context.CancelFunc(func() {})
+		if c.timeout > 0 {
+			rctx, cancel = context.WithTimeout(ctx, c.timeout)
+		}
+		res := c.syncRepo(rctx, dir)
+		if res.err != nil && errors.Is(rctx.Err(), context.DeadlineExceeded) {
+			res.err = fmt.Errorf("timed out after %v: %w", c.timeout, res.err)
+		}
+		cancel()
+		out <- res
+	}
+}
+
+func (c syncCmd) syncRepo(ctx context.Context, dir string) syncResult {
+	res := syncResult{
+		dir: c.repoName(dir),
+	}
+
+	wd, err := gitWorkDir(ctx, dir)
+	if errors.Is(err, ErrNoGitDir) {
+		res.skip = "not a repo"
+		return res
+	} else if err != nil {
+		res.err = err
 		return res
 	}
-	cmd = exec.Command("git", "merge", "--ff-only", "--autostash")
+	if isBareRepo(ctx, wd) {
+		return c.syncMirror(ctx, wd, res)
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--short", "HEAD")
 	cmd.Dir = wd
-	out, err = cmd.CombinedOutput()
+	out, err := combinedOutput(cmd)
 	if err != nil {
-		res.err = fmt.Errorf("merge: %w\n%s", err, out)
+		if !hasRefs(ctx, wd, "refs/heads") {
+			res.skip = "empty repo, nothing to sync"
+			return res
+		}
+		res.err = withKind(ErrGit, fmt.Errorf("get old ref: %w", err))
 		return res
 	}
+	res.oldRef = string(bytes.TrimSpace(out))
+
+	if c.skipNoUpstream {
+		cmd = exec.CommandContext(ctx, "git", "for-each-ref", "--count=1", "refs/remotes/origin")
+		cmd.Dir = wd
+		out, err = combinedOutput(cmd)
+		if err != nil {
+			res.err = withKind(ErrGit, fmt.Errorf("list remote refs: %w\n%s", err, out))
+			return res
+		}
+		if len(bytes.TrimSpace(out)) == 0 {
+			res.skip = "local only"
+			res.newRef = res.oldRef
+			return res
+		}
+	}
+
+	// ensure we're on the default branch
+	defaultBranch := c.branchOverride(ctx, wd, res.dir)
+	if defaultBranch != "" {
+		res.info = append(res.info, "branch "+defaultBranch)
+	} else {
+		cmd = exec.CommandContext(ctx, "git", "rev-parse", "--abbrev-ref", "origin/HEAD")
+		cmd.Dir = wd
+		out, err = combinedOutput(cmd)
+		if err != nil {
+			if !hasRefs(ctx, wd, "refs/remotes/origin") {
+				res.skip = "empty remote, nothing to sync"
+				res.newRef = res.oldRef
+				return res
+			}
+			// older clones may not have origin/HEAD, ask the remote for it
+			cmd = exec.CommandContext(ctx, "git", "remote", "set-head", "origin", "--auto")
+			cmd.Dir = wd
+			release := c.hosts.acquireOrigin(ctx, wd)
+			setOut, setErr := combinedOutput(cmd)
+			release()
+			if setErr != nil {
+				res.err = withKind(ErrNoDefaultBranch, fmt.Errorf("get remote default branch: %w\n%s\nset origin/HEAD: %v\n%s", err, out, setErr, setOut))
+				return res
+			}
+			res.info = append(res.info, "set origin/HEAD")
+
+			cmd = exec.CommandContext(ctx, "git", "rev-parse", "--abbrev-ref", "origin/HEAD")
+			cmd.Dir = wd
+			out, err = combinedOutput(cmd)
+			if err != nil {
+				res.err = withKind(ErrNoDefaultBranch, fmt.Errorf("get remote default branch: %w\n%s", err, out))
+				return res
+			}
+		}
+
+		defaultBranch = path.Base(string(bytes.TrimSpace(out)))
+	}
+
+	var dirty bool
+	if c.skipDirty {
+		dirty, err = isDirty(ctx, wd, false)
+		if err != nil {
+			res.err = err
+			return res
+		}
+	}
+
+	var otherBranch string
+	if c.keepBranch {
+		current, err := currentBranch(ctx, wd)
+		if err != nil {
+			res.err = err
+			return res
+		}
+		if current != defaultBranch {
+			otherBranch = current
+		}
+	}
+
+	// failures from here on are collected,
+	// so a repo that can't be checked out or merged still gets fetched
+	var errs []error
+	var checkoutFailed bool
+	if !dirty && otherBranch == "" {
+		cmd = exec.CommandContext(ctx, "git", "checkout", defaultBranch)
+		cmd.Dir = wd
+		out, err = combinedOutput(cmd)
+		if err != nil {
+			errs = append(errs, withKind(ErrCheckout, fmt.Errorf("switch to default branch: %w\n%s", err, out)))
+			checkoutFailed = true
+		}
+	}
+
+	// the network part of the sync
+	release := c.hosts.acquireOrigin(ctx, wd)
+	if c.deepen {
+		if _, err := os.Stat(filepath.Join(wd, ".git", "shallow")); err == nil {
+			cmd = exec.CommandContext(ctx, "git", "fetch", "--unshallow")
+			cmd.Dir = wd
+			out, err = combinedOutput(cmd)
+			if err != nil {
+				errs = append(errs, withKind(ErrFetch, fmt.Errorf("unshallow: %w\n%s", err, out)))
+			} else {
+				res.info = append(res.info, "deepened")
+			}
+		}
+	}
 
-	cmd = exec.Command("git", "worktree", "prune")
+	fetchArgs := c.fetchArgs(defaultBranch)
+	if c.singleBranch {
+		res.info = append(res.info, "single branch")
+	}
+	cmd = exec.CommandContext(ctx, "git", fetchArgs...)
 	cmd.Dir = wd
-	out, err = cmd.CombinedOutput()
+	out, err = combinedOutput(cmd)
 	if err != nil {
-		res.err = fmt.Errorf("prune worktrees: %w\n%s", err, out)
-		return res
+		errs = append(errs, withKind(ErrFetch, fmt.Errorf("fetch: %w\n%s", err, out)))
+	}
+
+	if c.notes {
+		updated, err := fetchNotes(ctx, wd)
+		if err != nil {
+			errs = append(errs, err)
+		} else if updated {
+			res.info = append(res.info, "notes updated")
+		}
+	}
+	release()
+
+	if dirty {
+		res.info = append(res.info, "dirty, skipped merge")
+	} else if otherBranch == "HEAD" {
+		res.info = append(res.info, "detached HEAD, skipped merge")
+	} else if otherBranch != "" {
+		res.info = append(res.info, "on branch "+otherBranch+", skipped merge")
+	} else if checkoutFailed {
+		res.info = append(res.info, "skipped merge")
+	} else if err := c.mergeUpstream(ctx, wd, &res); err != nil {
+		errs = append(errs, err)
+	} else if c.submodules {
+		if err := updateSubmodules(ctx, wd); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	cmd = exec.CommandContext(ctx, "git", "worktree", "prune")
+	cmd.Dir = wd
+	out, err = combinedOutput(cmd)
+	if err != nil {
+		errs = append(errs, withKind(ErrGit, fmt.Errorf("prune worktrees: %w\n%s", err, out)))
+	}
+
+	if c.gc {
+		cmd = exec.CommandContext(ctx, "git", "gc", "--auto", "--quiet")
+		cmd.Dir = wd
+		out, err = combinedOutput(cmd)
+		if err != nil {
+			errs = append(errs, withKind(ErrGit, fmt.Errorf("gc: %w\n%s", err, out)))
+		}
 	}
+	res.err = errors.Join(errs...)
 
-	cmd = exec.Command("git", "rev-parse", "--short", "HEAD")
+	cmd = exec.CommandContext(ctx, "git", "rev-parse", "--short", "HEAD")
 	cmd.Dir = wd
-	out, err = cmd.CombinedOutput()
+	out, err = combinedOutput(cmd)
 	if err != nil {
-		res.err = fmt.Errorf("get new ref: %w\n%s", err, out)
+		res.err = errors.Join(res.err, withKind(ErrGit, fmt.Errorf("get new ref: %w\n%s", err, out)))
 		return res
 	}
 	res.newRef = string(bytes.TrimSpace(out))
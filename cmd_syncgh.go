@@ -1,32 +1,75 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
+	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/go-github/v48/github"
 	"github.com/google/subcommands"
 	"golang.org/x/oauth2"
 )
 
-const (
-	GithubTokenEnv = "GH_TOKEN"
-)
-
 type syncGHCmd struct {
-	archived bool
-	dryRun   bool
-	prune    bool
-	worktree bool
-	sync     bool
-	users    []string
-	orgs     []string
-	exclude  []string
+	archived   bool
+	forks      bool
+	since      time.Duration
+	visibility string
+	dryRun     bool
+	check      bool
+	prune      bool
+	yes        bool
+	forcePrune bool
+	worktree   bool
+	sync       bool
+	force      bool
+	resume     bool
+	latestTag  bool
+	single     bool
+	mirror     bool
+	renames    bool
+	verify     bool
+	postClone  string
+	rate       bool
+	adopt      bool
+	toTrash    bool
+	trashDir   string
+	app        githubApp
+	ssh        bool
+	baseURL    string
+	parallel   int
+	maxWait    time.Duration
+	config     string
+	dir        string
+	byOwner    bool
+	scanDir    string
+	outputDir  string
+	users      []string
+	orgs       []string
+	team       string
+	perHost    int
+	hosts      *hostLimiter
+	exclude    []string
+	match      *regexp.Regexp
+	excludeRe  *regexp.Regexp
+	languages  []string
+	topics     []string
 }
 
 func (c syncGHCmd) Name() string { return "syncgh" }
@@ -35,18 +78,74 @@ func (c syncGHCmd) Synopsis() string {
 }
 
 func (c syncGHCmd) Usage() string {
-	return `repos syncgh [-archived] [-dryrun] [-prune] [-worktree] [-user=XXX]... [-org=XXX]...
+	return `repos syncgh [-archived] [-forks] [-since=DURATION] [-visibility=all|public|private] [-dryrun] [-check] [-prune] [-yes] [-force-prune] [-worktree] [-force] [-resume] [-show-latest-tag] [-single-branch] [-mirror] [-remote-rename] [-verify] [-post-clone=CMD] [-rate] [-clone-into-existing] [-prune-to-trash] [-trash-dir=DIR] [-ssh] [-base-url=URL] [-parallel=N] [-concurrency-per-host=N] [-max-wait=DURATION] [-config=PATH] [-q] [-v] [-dir=PATH] [-group-by-owner] [-scan-dir=DIR] [-output-dir=DIR] [-match=REGEX] [-exclude-regex=REGEX] [-language=NAME]... [-topic=NAME]... [-user=XXX]... [-org=XXX]... [-team=SLUG]
+
+Authentication uses the GH_TOKEN environent variable,
+or a github app installation if -app-id, -app-installation-id and -app-key are given.
+-base-url points at a github enterprise instance, repos are then cloned from its host.
+Repo listings are cached in the user cache dir and revalidated with their ETag,
+so unchanged listings don't count against the rate limit.
+
+The -post-clone command is run with sh in each new clone,
+with the repo name and path as $1 and $2,
+and REPOS_OWNER, REPOS_NAME, REPOS_PATH in its environment.
+
+Default users, orgs, and -archived, -worktree, -prune values
+are read from the -config json file, for example:
+  {"users": ["seankhliao"], "orgs": ["erred"], "worktree": true}
+Users and orgs from the file are only used if none are given as flags.
 
-Authentication uses the GH_TOKEN environent variable.
+-dir is the directory of repos to sync, defaulting to $REPOS_ROOT or the current directory,
+-scan-dir and -output-dir default to it.
+-group-by-owner nests repos as owner/repo, so repos of the same name from different owners don't collide.
+
+-match and -exclude-regex limit the sync to repos with matching names,
+-exclude-regex winning if both match.
+Local repos filtered out this way are left alone, never pruned.
+
+-prune deletes repos permanently, after asking for confirmation
+unless -yes is given or stdin isn't a terminal,
+-prune-to-trash moves them to the trash dir instead (default scan-dir/.trash).
+Repos with uncommitted changes or unpushed commits are only deleted with -force-prune.
 `
 }
 
 func (c *syncGHCmd) SetFlags(fset *flag.FlagSet) {
 	fset.BoolVar(&c.archived, "archived", false, "include archived repositories")
+	fset.BoolVar(&c.forks, "forks", false, "clone forked repositories, existing forks are kept either way")
+	fset.DurationVar(&c.since, "since", 0, "only clone repos pushed to within this long, existing repos are kept either way")
+	fset.StringVar(&c.visibility, "visibility", "all", "only clone all, public, or private repos, existing repos are kept either way")
 	fset.BoolVar(&c.dryRun, "dryrun", false, "print actions instead of executing them")
+	fset.BoolVar(&c.check, "check", false, "like -dryrun, but exit non-zero if any repos would be cloned, moved or pruned, without syncing existing repos")
 	fset.BoolVar(&c.prune, "prune", false, "prune repositories not found on the remote")
+	fset.BoolVar(&c.yes, "yes", false, "prune without asking for confirmation")
+	fset.BoolVar(&c.forcePrune, "force-prune", false, "prune repos even if they have local changes")
 	fset.BoolVar(&c.worktree, "worktree", false, "nest checkouts under repo/default")
 	fset.BoolVar(&c.sync, "sync", false, "sync existing repos")
+	fset.BoolVar(&c.force, "force", false, "ignore the lock held by another sync")
+	fset.BoolVar(&c.resume, "resume", false, "redo clones left incomplete by an interrupted run")
+	fset.BoolVar(&c.latestTag, "show-latest-tag", false, "print the latest tag of newly cloned repos")
+	fset.BoolVar(&c.single, "single-branch", false, "only clone the default branch")
+	fset.BoolVar(&c.mirror, "mirror", false, "keep bare mirror clones named repo.git")
+	fset.BoolVar(&c.renames, "remote-rename", false, "move local repos renamed or transferred on github instead of pruning and recloning")
+	fset.BoolVar(&c.verify, "verify", false, "check new clones have a valid checkout, recloning once if not")
+	fset.StringVar(&c.postClone, "post-clone", "", "shell command to run in each new clone")
+	fset.BoolVar(&c.rate, "rate", false, "periodically print clone throughput and an estimated time remaining")
+	fset.BoolVar(&c.adopt, "clone-into-existing", false, "set up existing directories that aren't git repos as clones")
+	fset.BoolVar(&c.toTrash, "prune-to-trash", false, "move pruned repos to the trash dir instead of deleting them")
+	fset.StringVar(&c.trashDir, "trash-dir", "", "directory for -prune-to-trash (default scan-dir/.trash)")
+	c.app.SetFlags(fset)
+	fset.BoolVar(&c.ssh, "ssh", false, "clone over ssh instead of https")
+	fset.StringVar(&c.baseURL, "base-url", os.Getenv(GithubBaseURLEnv), "github enterprise url, defaults to $"+GithubBaseURLEnv)
+	fset.IntVar(&c.parallel, "parallel", 5, "parallel clones and syncs to run")
+	fset.IntVar(&c.perHost, "concurrency-per-host", 0, "max concurrent clones and fetches against each remote host, 0 for no limit beyond -parallel")
+	fset.DurationVar(&c.maxWait, "max-wait", 15*time.Minute, "longest to wait for the github rate limit to reset")
+	logFlags(fset)
+	fset.StringVar(&c.config, "config", defaultConfigPath(), "json file with default users, orgs and flags")
+	fset.StringVar(&c.dir, "dir", envOr(RootEnv, "."), "directory of repos to sync, defaults to $"+RootEnv+" or the current directory")
+	fset.BoolVar(&c.byOwner, "group-by-owner", false, "clone into owner/repo subdirectories")
+	fset.StringVar(&c.scanDir, "scan-dir", "", "directory to scan for existing repos (default -dir)")
+	fset.StringVar(&c.outputDir, "output-dir", "", "directory to clone new repos into (default -dir)")
 	fset.Func("user", "github user", func(s string) error {
 		c.users = append(c.users, s)
 		return nil
@@ -55,10 +154,29 @@ func (c *syncGHCmd) SetFlags(fset *flag.FlagSet) {
 		c.orgs = append(c.orgs, s)
 		return nil
 	})
+	fset.StringVar(&c.team, "team", "", "only sync the -org repos this team slug has access to")
 	fset.Func("exclude", "glob pattern against repo name to exclude, repeatable", func(s string) error {
 		c.exclude = append(c.exclude, s)
 		return nil
 	})
+	fset.Func("match", "only sync repos with names matching this regex", func(s string) error {
+		var err error
+		c.match, err = regexp.Compile(s)
+		return err
+	})
+	fset.Func("exclude-regex", "don't sync repos with names matching this regex, overrides -match", func(s string) error {
+		var err error
+		c.excludeRe, err = regexp.Compile(s)
+		return err
+	})
+	fset.Func("language", "only clone repos with this primary language, repeatable, existing repos are kept either way", func(s string) error {
+		c.languages = append(c.languages, s)
+		return nil
+	})
+	fset.Func("topic", "only clone repos with at least one of these topics, repeatable, existing repos are kept either way", func(s string) error {
+		c.topics = append(c.topics, strings.ToLower(s))
+		return nil
+	})
 }
 
 func (c syncGHCmd) Execute(ctx context.Context, fset *flag.FlagSet, args ...any) subcommands.ExitStatus {
@@ -67,19 +185,69 @@ func (c syncGHCmd) Execute(ctx context.Context, fset *flag.FlagSet, args ...any)
 		return subcommands.ExitUsageError
 	}
 
+	set := setFlags(fset)
+	conf, err := loadConfig(c.config, set["config"])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "repos syncgh:", err)
+		return subcommands.ExitFailure
+	}
+	c.applyConfig(conf, set)
+	if c.scanDir == "" {
+		c.scanDir = c.dir
+	}
+	if c.outputDir == "" {
+		c.outputDir = c.dir
+	}
+
 	if len(c.orgs)+len(c.users) == 0 {
 		fmt.Fprintln(os.Stderr, "no users or orgs given")
 		return subcommands.ExitUsageError
 	}
+	if c.team != "" && len(dedupeLogins(c.orgs)) != 1 {
+		fmt.Fprintln(os.Stderr, "repos syncgh: -team needs exactly one -org")
+		return subcommands.ExitUsageError
+	}
+	switch c.visibility {
+	case "all", "public", "private":
+	default:
+		fmt.Fprintln(os.Stderr, "repos syncgh: -visibility must be one of all, public, private")
+		return subcommands.ExitUsageError
+	}
+	if c.mirror && (c.worktree || c.adopt) {
+		fmt.Fprintln(os.Stderr, "repos syncgh: -mirror can't be used with -worktree or -clone-into-existing")
+		return subcommands.ExitUsageError
+	}
 
-	err := c.run(ctx)
+	if c.check {
+		c.dryRun = true
+	}
+
+	// cancel in flight git commands on interrupt, so the lock is released
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	// lock what the follow up sync works on, so a sync of -dir waits for us
+	release, err := acquireLock(c.dir, c.force)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "repos syncgh:", err)
+		return subcommands.ExitFailure
+	}
+	defer release()
+
+	err = c.run(ctx)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "repos syncgh:", err)
 		return subcommands.ExitFailure
 	}
+	if c.check {
+		return subcommands.ExitSuccess
+	}
 
 	synccmd := syncCmd{
-		parallel: 5,
+		dir:          c.dir,
+		parallel:     c.parallel,
+		groupByOwner: c.byOwner,
+		perHost:      c.perHost,
 	}
 	err = synccmd.run(ctx)
 	if err != nil {
@@ -91,23 +259,36 @@ func (c syncGHCmd) Execute(ctx context.Context, fset *flag.FlagSet, args ...any)
 }
 
 func (c syncGHCmd) run(ctx context.Context) error {
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: os.Getenv(GithubTokenEnv)},
-	)
-	tc := oauth2.NewClient(ctx, ts)
-	client := github.NewClient(tc)
+	// unchanged repo lists are replayed from the cache
+	cache, err := newETagTransport(http.DefaultTransport)
+	if err != nil {
+		return err
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: cache})
+	client, err := newGitHubClient(ctx, c.app, c.baseURL)
+	if err != nil {
+		return err
+	}
 
-	allReposM := make(map[string]string)
-	for _, user := range c.users {
+	allReposM := make(map[string]remoteRepo)
+	for _, user := range dedupeLogins(c.users) {
 		for page := 1; true; page++ {
-			repos, res, err := client.Repositories.List(ctx, user, &github.RepositoryListOptions{
-				ListOptions: github.ListOptions{
-					Page:    page,
-					PerPage: 100,
-				},
+			var repos []*github.Repository
+			var res *github.Response
+			err := retryGitHub(ctx, c.maxWait, func() (*github.Response, error) {
+				var err error
+				// list everything, -visibility only marks repos as keep
+				// so that repos left out aren't pruned
+				repos, res, err = client.Repositories.List(ctx, user, &github.RepositoryListOptions{
+					ListOptions: github.ListOptions{
+						Page:    page,
+						PerPage: 100,
+					},
+				})
+				return res, err
 			})
 			if err != nil {
-				return fmt.Errorf("list repos page %d for %s: %v", page, user, err)
+				return withKind(ErrGitHubAPI, fmt.Errorf("list repos page %d for %s: %w", page, user, err))
 			}
 			err = c.addRepos(allReposM, repos)
 			if err != nil {
@@ -118,16 +299,27 @@ func (c syncGHCmd) run(ctx context.Context) error {
 			}
 		}
 	}
-	for _, org := range c.orgs {
+	for _, org := range dedupeLogins(c.orgs) {
 		for page := 1; true; page++ {
-			repos, res, err := client.Repositories.ListByOrg(ctx, org, &github.RepositoryListByOrgOptions{
-				ListOptions: github.ListOptions{
+			var repos []*github.Repository
+			var res *github.Response
+			err := retryGitHub(ctx, c.maxWait, func() (*github.Response, error) {
+				var err error
+				opts := github.ListOptions{
 					Page:    page,
 					PerPage: 100,
-				},
+				}
+				if c.team != "" {
+					repos, res, err = client.Teams.ListTeamReposBySlug(ctx, org, c.team, &opts)
+					return res, err
+				}
+				repos, res, err = client.Repositories.ListByOrg(ctx, org, &github.RepositoryListByOrgOptions{
+					ListOptions: opts,
+				})
+				return res, err
 			})
 			if err != nil {
-				return fmt.Errorf("list repos page %d for %s: %v", page, org, err)
+				return withKind(ErrGitHubAPI, fmt.Errorf("list repos page %d for %s: %w", page, org, err))
 			}
 			err = c.addRepos(allReposM, repos)
 			if err != nil {
@@ -139,89 +331,816 @@ func (c syncGHCmd) run(ctx context.Context) error {
 		}
 	}
 
+	return c.reconcile(ctx, allReposM)
+}
+
+// dedupeLogins removes repeated github logins, which are case insensitive,
+// keeping the first occurrence.
+func dedupeLogins(logins []string) []string {
+	seen := make(map[string]bool, len(logins))
+	var out []string
+	for _, login := range logins {
+		k := strings.ToLower(login)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		out = append(out, login)
+	}
+	return out
+}
+
+// applyConfig fills in values from conf not given as flags.
+func (c *syncGHCmd) applyConfig(conf config, set map[string]bool) {
+	if len(c.users)+len(c.orgs) == 0 {
+		c.users = conf.Users
+		c.orgs = conf.Orgs
+	}
+	if !set["archived"] {
+		c.archived = conf.Archived
+	}
+	if !set["worktree"] {
+		c.worktree = conf.Worktree
+	}
+	if !set["prune"] {
+		c.prune = conf.Prune
+	}
+}
+
+// wantVisibility reports whether a repo passes -visibility,
+// unset as when reused by other commands means all.
+func (c syncGHCmd) wantVisibility(private bool) bool {
+	switch c.visibility {
+	case "public":
+		return !private
+	case "private":
+		return private
+	}
+	return true
+}
+
+// wantLanguage reports whether a repo's primary language passes -language,
+// repos without a detected language only pass if no languages are given.
+func (c syncGHCmd) wantLanguage(lang string) bool {
+	if len(c.languages) == 0 {
+		return true
+	}
+	for _, want := range c.languages {
+		if lang != "" && strings.EqualFold(lang, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// wantTopics reports whether a repo has one of the -topic topics.
+// The repo list endpoints include topics, github lowercases them.
+func (c syncGHCmd) wantTopics(topics []string) bool {
+	if len(c.topics) == 0 {
+		return true
+	}
+	for _, topic := range topics {
+		for _, want := range c.topics {
+			if topic == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dirName is the directory repo is checked out in,
+// bare mirrors are named repo.git.
+func (c syncGHCmd) dirName(repo string) string {
+	if c.mirror {
+		return repo + ".git"
+	}
+	return repo
+}
+
+// wantName reports whether the repo name passes the -match and -exclude-regex filters.
+func (c syncGHCmd) wantName(name string) bool {
+	if c.excludeRe != nil && c.excludeRe.MatchString(name) {
+		return false
+	}
+	return c.match == nil || c.match.MatchString(name)
+}
+
+// reconcile clones and prunes local repos to match allReposM.
+// reconcile clones, moves and prunes local repos to match allReposM.
+// Once ctx is done, no further stages are started.
+func (c syncGHCmd) reconcile(ctx context.Context, allReposM map[string]remoteRepo) error {
+	c.hosts = newHostLimiter(c.perHost)
 	localRepoM := make(map[string]struct{})
-	des, err := os.ReadDir(".")
+	names, err := scanRepoNames(c.scanDir, c.byOwner)
+	if errors.Is(err, fs.ErrNotExist) {
+		// nothing cloned yet
+	} else if err != nil {
+		return err
+	}
+	for i, name := range names {
+		if c.mirror {
+			name = strings.TrimSuffix(name, ".git")
+			names[i] = name
+		}
+		if c.wantName(name) {
+			localRepoM[name] = struct{}{}
+		}
+	}
+	for name := range allReposM {
+		if !c.wantName(name) {
+			delete(allReposM, name)
+		}
+	}
+
+	// unfinished clones of repos that are no longer cloned are forgotten,
+	// the rest are carried over into the new journal unless -resume resolves them
+	incomplete, err := incompleteClones(c.outputDir)
 	if err != nil {
-		return fmt.Errorf("read .: %w", err)
+		return err
 	}
-	for _, de := range des {
-		if !de.IsDir() {
+	var carry []string
+	for _, r := range incomplete {
+		if remote, ok := allReposM[r]; !ok || remote.keep {
+			continue
+		}
+		if !c.resume {
+			carry = append(carry, r)
+			continue
+		}
+		dst := filepath.Join(c.outputDir, c.dirName(r))
+		remove, reason := resumeClone(ctx, dst)
+		if !remove {
+			if reason != "" {
+				fmt.Fprintf(os.Stderr, "not removing %s (incomplete clone): %s\n", dst, reason)
+				carry = append(carry, r)
+			}
 			continue
 		}
-		localRepoM[de.Name()] = struct{}{}
+		msg := "rm -rf " + dst + " (incomplete clone)"
+		var err error
+		if !c.dryRun {
+			err = os.RemoveAll(dst)
+			if err != nil {
+				msg += ": " + err.Error()
+			}
+		}
+		logAction(msg, err)
+		delete(localRepoM, r)
 	}
 
-	var toClone []struct {
-		owner, repo string
-	}
+	// changes to the local repos, for -check
+	var drift int
+	var toClone []cloneTarget
 	for k, v := range allReposM {
-		if _, ok := localRepoM[k]; !ok {
-			toClone = append(toClone, struct {
-				owner string
-				repo  string
-			}{
-				v, k,
-			})
+		if _, ok := localRepoM[k]; !ok && !v.keep {
+			toClone = append(toClone, cloneTarget{v.owner, k, v.url, v.id, v.private})
 		}
 	}
+	if c.adopt {
+		for _, name := range names {
+			r, ok := allReposM[name]
+			if !ok || r.keep {
+				continue
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			dir := filepath.Join(c.scanDir, name)
+			if _, err := gitWorkDir(ctx, dir); err == nil {
+				continue
+			}
+			drift++
+			u := r.url
+			msg := "git init " + dir + " && git fetch " + u
+			var err error
+			if !c.dryRun {
+				err = adoptDir(ctx, dir, u, c.hosts)
+				if err == nil {
+					err = setGitHubID(ctx, dir, r.id)
+				}
+				if err != nil {
+					msg += ": " + err.Error()
+				}
+			}
+			logAction(msg, err)
+		}
+	}
+
 	sort.Slice(toClone, func(i, j int) bool {
 		if toClone[i].owner != toClone[j].owner {
 			return toClone[i].owner < toClone[j].owner
 		}
 		return toClone[i].repo < toClone[j].repo
 	})
-	var toPrune []string
+	var missing []string
 	for r := range localRepoM {
 		if _, ok := allReposM[r]; !ok {
-			toPrune = append(toPrune, r)
+			missing = append(missing, r)
 		}
 	}
-	sort.Strings(toPrune)
+	sort.Strings(missing)
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 
+	// clones and moves, prunes are added once they're known
+	drift += len(toClone)
+	if c.renames {
+		toClone, missing = c.moveRenamed(ctx, toClone, missing)
+	}
+	var toPrune []string
+	if c.prune || c.toTrash {
+		toPrune = missing
+	}
+
+	var journal *cloneJournal
+	if !c.dryRun {
+		journal, err = openCloneJournal(c.outputDir, carry)
+		if err != nil {
+			return err
+		}
+	}
+	rate := newCloneRate(len(toClone))
+	todo := make(chan cloneTarget, len(toClone))
 	for _, r := range toClone {
-		u := fmt.Sprintf("https://github.com/%s/%s", r.owner, r.repo)
-		dst := r.repo
-		if c.worktree {
-			dst += "/default"
+		todo <- r
+	}
+	close(todo)
+	parallel := c.parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	errc := make(chan error, parallel)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range todo {
+				if ctx.Err() != nil {
+					return
+				}
+				err := c.clone(ctx, journal, rate, r)
+				if err != nil {
+					errc <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errc)
+	if err := <-errc; err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		// leave the journal for -resume and don't prune on a partial run
+		return ctx.Err()
+	}
+	if !c.dryRun {
+		err = journal.finish()
+		if err != nil {
+			return err
 		}
-		msg := "git clone " + u + " " + dst
-		if !c.dryRun {
-			cmd := exec.Command("git", "clone", u, dst)
-			out, err := cmd.CombinedOutput()
-			if err != nil {
-				msg += ": " + err.Error() + "\n" + string(out)
+	}
+	if !c.toTrash && !c.forcePrune {
+		var safe []string
+		for _, r := range toPrune {
+			dst := filepath.Join(c.scanDir, c.dirName(r))
+			if reason := localWork(ctx, dst); reason != "" {
+				fmt.Fprintf(os.Stderr, "not pruning %s: %s\n", dst, reason)
+				continue
 			}
+			safe = append(safe, r)
+		}
+		toPrune = safe
+	}
+	if len(toPrune) > 0 && !c.toTrash && !c.dryRun && !c.yes && isTerminal(os.Stdin) {
+		if !confirm(c.scanDir, toPrune) {
+			fmt.Fprintln(os.Stderr, "not pruning")
+			toPrune = nil
 		}
-		fmt.Fprintln(os.Stderr, msg)
 	}
 	for _, r := range toPrune {
-		msg := "rm -rf " + r
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		dst := filepath.Join(c.scanDir, c.dirName(r))
+		if c.toTrash {
+			c.trash(dst)
+			continue
+		}
+		msg := "rm -rf " + dst
+		var err error
 		if !c.dryRun {
-			err := os.RemoveAll(r)
+			err = os.RemoveAll(dst)
+			if err != nil {
+				msg += ": " + err.Error()
+			}
+		}
+		logAction(msg, err)
+	}
+	drift += len(toPrune)
+	if c.check && drift > 0 {
+		return withKind(ErrDrift, fmt.Errorf("%d repos would be cloned, moved or pruned", drift))
+	}
+	return nil
+}
+
+// resumeClone decides what to do with the dir of a clone that never finished.
+// Only a clone that never got a HEAD is removed for recloning,
+// a dir with a HEAD may have been repaired and worked in since:
+// it is kept, with the reason if it has local work or isn't a repo.
+func resumeClone(ctx context.Context, dir string) (remove bool, reason string) {
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		return true, ""
+	}
+	wd, err := gitWorkDir(ctx, dir)
+	if err != nil {
+		return false, "not a git repo"
+	}
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--verify", "--quiet", "HEAD")
+	cmd.Dir = wd
+	if cmd.Run() != nil {
+		return ctx.Err() == nil, ""
+	}
+	return false, localWork(ctx, dir)
+}
+
+// localWork describes work in dir that deleting it would lose,
+// or is empty if there is none.
+func localWork(ctx context.Context, dir string) string {
+	wd, err := gitWorkDir(ctx, dir)
+	if err != nil {
+		return "not a git repo, can't check for local changes"
+	}
+	if isBareRepo(ctx, wd) {
+		// mirrors only hold what was fetched
+		return ""
+	}
+	dirty, err := isDirty(ctx, wd, true)
+	if err != nil {
+		return err.Error()
+	} else if dirty {
+		return "uncommitted changes"
+	}
+	cmd := exec.CommandContext(ctx, "git", "rev-list", "--count", "--branches", "--not", "--remotes")
+	cmd.Dir = wd
+	out, err := combinedOutput(cmd)
+	if err != nil {
+		return fmt.Sprintf("count unpushed commits: %v\n%s", err, out)
+	}
+	if n := string(bytes.TrimSpace(out)); n != "0" {
+		return n + " unpushed commits"
+	}
+	return ""
+}
+
+// confirm lists the repos under dir to be deleted
+// and asks for confirmation on stdin.
+func confirm(dir string, repos []string) bool {
+	for _, r := range repos {
+		fmt.Fprintln(os.Stderr, "  "+filepath.Join(dir, r))
+	}
+	fmt.Fprintf(os.Stderr, "delete %d repos? [y/N] ", len(repos))
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// isTerminal reports whether f is a terminal and not a pipe or file.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	// so is /dev/null
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(fi, null)
+}
+
+// clone clones r, printing the outcome.
+// Only failures to record progress in the journal are returned.
+func (c syncGHCmd) clone(ctx context.Context, journal *cloneJournal, rate *cloneRate, r cloneTarget) error {
+	u := r.url
+	dst := filepath.Join(c.outputDir, c.dirName(r.repo))
+	if c.worktree {
+		dst += "/default"
+	}
+	args := []string{"clone"}
+	if c.single {
+		args = append(args, "--single-branch")
+	}
+	if c.mirror {
+		args = append(args, "--mirror")
+	}
+	args = append(args, u, dst)
+	msg := "git " + strings.Join(args, " ")
+	if r.private {
+		msg += " (private)"
+	}
+	var failed error
+	if !c.dryRun {
+		err := journal.start(r.repo)
+		if err != nil {
+			return err
+		}
+		release := c.hosts.acquire(ctx, remoteHost(u))
+		err = runClone(ctx, args, r.repo)
+		if err == nil && c.verify && !c.mirror {
+			if verr := verifyClone(ctx, dst); verr != nil {
+				msg += " (verify: " + verr.Error() + ", recloning)"
+				err = os.RemoveAll(dst)
+				if err == nil {
+					err = runClone(ctx, args, r.repo)
+				}
+				if err == nil {
+					err = verifyClone(ctx, dst)
+				}
+			}
+		}
+		release()
+		if err != nil {
+			msg += ": " + err.Error()
+			failed = err
+		} else {
+			err = journal.done(r.repo)
+			if err != nil {
+				return err
+			}
+			err = setGitHubID(ctx, dst, r.id)
 			if err != nil {
 				msg += ": " + err.Error()
+				failed = err
+			}
+			if c.latestTag {
+				msg += " (" + latestTag(ctx, dst) + ")"
 			}
+			if c.postClone != "" {
+				err = runPostClone(ctx, c.postClone, r, dst)
+				if err != nil {
+					msg += ": post-clone: " + err.Error()
+					failed = err
+				}
+			}
+		}
+	}
+	if c.rate && !c.dryRun {
+		size, _ := dirSize(dst)
+		if line, ok := rate.add(size); ok {
+			msg += "\n" + line
 		}
-		fmt.Fprintln(os.Stderr, msg)
 	}
+	logAction(msg, failed)
 	return nil
 }
 
-func (c syncGHCmd) addRepos(m map[string]string, repos []*github.Repository) error {
+// trash moves dir into the trash dir,
+// suffixed with the time so repeated prunes of a name don't collide.
+func (c syncGHCmd) trash(dir string) {
+	trashDir := c.trashDir
+	if trashDir == "" {
+		trashDir = filepath.Join(c.scanDir, trashDirName)
+	}
+	dst := filepath.Join(trashDir, filepath.Base(dir)+"."+time.Now().Format("20060102T150405"))
+	msg := "mv " + dir + " " + dst
+	var err error
+	if !c.dryRun {
+		err = os.MkdirAll(trashDir, 0o755)
+		if err == nil {
+			err = os.Rename(dir, dst)
+		}
+		if err != nil {
+			msg += ": " + err.Error()
+		}
+	}
+	logAction(msg, err)
+}
+
+// remoteRepo is a repo listed from a hosting provider.
+type remoteRepo struct {
+	owner   string
+	url     string // clone url
+	id      int64  // github id, 0 for other providers
+	keep    bool   // filtered out, not cloned but not pruned either
+	private bool
+}
+
+type cloneTarget struct {
+	owner, repo string
+	url         string
+	id          int64
+	private     bool
+}
+
+func (c syncGHCmd) cloneURL(owner, repo string) string {
+	host := githubHost(c.baseURL)
+	if c.ssh {
+		return fmt.Sprintf("git@%s:%s/%s.git", host, owner, repo)
+	}
+	return fmt.Sprintf("https://%s/%s/%s", host, owner, repo)
+}
+
+func (c syncGHCmd) addRepos(m map[string]remoteRepo, repos []*github.Repository) error {
+	cutoff := time.Now().Add(-c.since)
 repoLoop:
 	for _, repo := range repos {
-		if !c.archived && *repo.Archived {
+		name, owner := repo.GetName(), repo.GetOwner().GetLogin()
+		if name == "" || owner == "" {
+			fmt.Fprintf(os.Stderr, "skipping incomplete repo from github: id=%d name=%q owner=%q\n", repo.GetID(), name, owner)
+			continue
+		}
+		if !c.archived && repo.GetArchived() {
 			continue
 		}
 		for _, pattern := range c.exclude {
-			ok, err := filepath.Match(pattern, *repo.Name)
+			ok, err := filepath.Match(pattern, name)
 			if err != nil {
-				return fmt.Errorf("match exclude pattern %q against %q: %w", pattern, *repo.Name, err)
+				return fmt.Errorf("match exclude pattern %q against %q: %w", pattern, name, err)
 			} else if ok {
 				continue repoLoop
 			}
 		}
-		m[*repo.Name] = *repo.Owner.Login
+		key := name
+		if c.byOwner {
+			key = owner + "/" + name
+		}
+		if prev, ok := m[key]; ok && !strings.EqualFold(prev.owner, owner) {
+			fmt.Fprintf(os.Stderr, "%s exists under both %s and %s, keeping %s, use -group-by-owner to clone both\n", name, prev.owner, owner, prev.owner)
+			continue
+		}
+		keep := !c.forks && repo.GetFork() ||
+			c.since > 0 && repo.GetPushedAt().Before(cutoff) ||
+			!c.wantVisibility(repo.GetPrivate()) ||
+			!c.wantLanguage(repo.GetLanguage()) ||
+			!c.wantTopics(repo.Topics)
+		m[key] = remoteRepo{
+			owner:   owner,
+			url:     c.cloneURL(owner, name),
+			id:      repo.GetID(),
+			keep:    keep,
+			private: repo.GetPrivate(),
+		}
+	}
+	return nil
+}
+
+const cloneRateInterval = 30 * time.Second
+
+// cloneRate tracks clone throughput for periodic progress lines.
+type cloneRate struct {
+	mu     sync.Mutex
+	start  time.Time
+	last   time.Time
+	total  int
+	done   int
+	nbytes int64
+}
+
+func newCloneRate(total int) *cloneRate {
+	now := time.Now()
+	return &cloneRate{start: now, last: now, total: total}
+}
+
+// add records a finished clone of size bytes,
+// returning a progress line if one is due.
+func (r *cloneRate) add(size int64) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.done++
+	r.nbytes += size
+	now := time.Now()
+	if r.done < r.total && now.Sub(r.last) < cloneRateInterval {
+		return "", false
+	}
+	r.last = now
+
+	elapsed := now.Sub(r.start)
+	perMin := float64(r.done) / elapsed.Minutes()
+	perSec := float64(r.nbytes) / elapsed.Seconds()
+	line := fmt.Sprintf("cloned %d/%d repos, %s in %s, %.1f repos/min, %s/s",
+		r.done, r.total, formatBytes(r.nbytes), elapsed.Round(time.Second), perMin, formatBytes(int64(perSec)))
+	if r.done < r.total {
+		eta := time.Duration(float64(r.total-r.done) / perMin * float64(time.Minute))
+		line += ", eta " + eta.Round(time.Second).String()
+	}
+	return line, true
+}
+
+// adoptDir turns an existing directory into a clone of u,
+// checking out the default branch over the files already there.
+// If existing files conflict with the checkout, the repo is left fetched
+// for the conflicts to be resolved by hand.
+func adoptDir(ctx context.Context, dir, u string, hosts *hostLimiter) error {
+	for _, args := range [][]string{
+		{"init"},
+		{"remote", "add", "origin", u},
+		{"fetch", "origin"},
+		{"remote", "set-head", "origin", "--auto"},
+	} {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		release := func() {}
+		if args[0] == "fetch" || args[0] == "remote" && args[1] == "set-head" {
+			release = hosts.acquire(ctx, remoteHost(u))
+		}
+		out, err := combinedOutput(cmd)
+		release()
+		if err != nil {
+			return withKind(ErrGit, fmt.Errorf("git %s: %w\n%s", args[0], err, out))
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--abbrev-ref", "origin/HEAD")
+	cmd.Dir = dir
+	out, err := combinedOutput(cmd)
+	if err != nil {
+		// empty remote, nothing to check out
+		return nil
+	}
+	branch := path.Base(string(bytes.TrimSpace(out)))
+
+	cmd = exec.CommandContext(ctx, "git", "checkout", branch)
+	cmd.Dir = dir
+	out, err = combinedOutput(cmd)
+	if err != nil {
+		return withKind(ErrCheckout, fmt.Errorf("checkout %s conflicts with existing files, resolve them and run git checkout %s: %w\n%s", branch, branch, err, out))
+	}
+	return nil
+}
+
+// runClone runs git with args, streaming its progress prefixed by name,
+// or with -q only printing its output if it fails.
+func runClone(ctx context.Context, args []string, name string) error {
+	if logging <= logQuiet {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		out, err := combinedOutput(cmd)
+		if err != nil {
+			return fmt.Errorf("%w\n%s", err, out)
+		}
+		return nil
+	}
+
+	args = append([]string{args[0], "--progress"}, args[1:]...)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	w := &prefixWriter{prefix: name}
+	cmd.Stdout = w
+	cmd.Stderr = w
+	debugf("$ git %s\n", strings.Join(args, " "))
+	err := cmd.Run()
+	w.Flush()
+	return err
+}
+
+func runPostClone(ctx context.Context, command string, r cloneTarget, dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", command, "sh", r.repo, abs)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"REPOS_OWNER="+r.owner,
+		"REPOS_NAME="+r.repo,
+		"REPOS_PATH="+abs,
+	)
+	out, err := combinedOutput(cmd)
+	if err != nil {
+		return fmt.Errorf("%w\n%s", err, out)
 	}
 	return nil
 }
+
+// verifyClone checks that a clone has a HEAD and a complete checkout.
+// Clones of empty repos have neither commits nor files and are fine.
+func verifyClone(ctx context.Context, dir string) error {
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--verify", "--quiet", "HEAD")
+	cmd.Dir = dir
+	err := cmd.Run()
+	if err != nil {
+		cmd = exec.CommandContext(ctx, "git", "for-each-ref", "--count=1", "refs/remotes")
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err != nil || len(bytes.TrimSpace(out)) > 0 {
+			return fmt.Errorf("no valid HEAD")
+		}
+		return nil
+	}
+
+	cmd = exec.CommandContext(ctx, "git", "status", "--porcelain")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("git status: %w", err)
+	} else if len(out) > 0 {
+		return fmt.Errorf("incomplete checkout")
+	}
+	return nil
+}
+
+// latestTag describes the most recent tag reachable from HEAD in dir.
+func latestTag(ctx context.Context, dir string) string {
+	cmd := exec.CommandContext(ctx, "git", "describe", "--tags", "--abbrev=0")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "no tags"
+	}
+	return "latest tag " + strings.TrimSpace(string(out))
+}
+
+// githubIDKey is the git config key holding the github id of a clone,
+// it stays the same across renames and transfers.
+const githubIDKey = "repos.githubid"
+
+func setGitHubID(ctx context.Context, dir string, id int64) error {
+	if id == 0 {
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, "git", "config", githubIDKey, strconv.FormatInt(id, 10))
+	cmd.Dir = dir
+	out, err := combinedOutput(cmd)
+	if err != nil {
+		return withKind(ErrGit, fmt.Errorf("record github id: %w\n%s", err, out))
+	}
+	return nil
+}
+
+func getGitHubID(ctx context.Context, dir string) int64 {
+	wd, err := gitWorkDir(ctx, dir)
+	if err != nil {
+		return 0
+	}
+	cmd := exec.CommandContext(ctx, "git", "config", "--get", githubIDKey)
+	cmd.Dir = wd
+	out, err := cmd.Output()
+	if err != nil {
+		return 0
+	}
+	id, _ := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	return id
+}
+
+// moveRenamed matches local repos missing on github to repos that would be cloned
+// by their github id, and moves and repoints them instead.
+func (c syncGHCmd) moveRenamed(ctx context.Context, toClone []cloneTarget, missing []string) ([]cloneTarget, []string) {
+	byID := make(map[int64]cloneTarget)
+	for _, r := range toClone {
+		if r.id != 0 {
+			byID[r.id] = r
+		}
+	}
+
+	moved := make(map[string]bool)
+	var keepMissing []string
+	for _, local := range missing {
+		src := filepath.Join(c.scanDir, c.dirName(local))
+		r, ok := byID[getGitHubID(ctx, src)]
+		if !ok || ctx.Err() != nil {
+			keepMissing = append(keepMissing, local)
+			continue
+		}
+		moved[r.repo] = true
+
+		dst := filepath.Join(c.outputDir, c.dirName(r.repo))
+		u := r.url
+		msg := "mv " + src + " " + dst + " && git remote set-url origin " + u
+		var err error
+		if !c.dryRun {
+			err = os.MkdirAll(filepath.Dir(dst), 0o755)
+			if err == nil {
+				err = os.Rename(src, dst)
+			}
+			var wd string
+			if err == nil {
+				wd, err = gitWorkDir(ctx, dst)
+			}
+			if err == nil {
+				cmd := exec.CommandContext(ctx, "git", "remote", "set-url", "origin", u)
+				cmd.Dir = wd
+				var out []byte
+				out, err = combinedOutput(cmd)
+				if err != nil {
+					err = fmt.Errorf("%w\n%s", err, out)
+				}
+			}
+			if err != nil {
+				msg += ": " + err.Error()
+			}
+		}
+		logAction(msg, err)
+	}
+
+	var keepClone []cloneTarget
+	for _, r := range toClone {
+		if !moved[r.repo] {
+			keepClone = append(keepClone, r)
+		}
+	}
+	return keepClone, keepMissing
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/subcommands"
+)
+
+const (
+	GitlabTokenEnv = "GL_TOKEN"
+)
+
+type syncGLCmd struct {
+	archived bool
+	dryRun   bool
+	prune    bool
+	worktree bool
+	force    bool
+	dir      string
+	baseURL  string
+	users    []string
+	groups   []string
+	exclude  []string
+}
+
+func (c syncGLCmd) Name() string { return "syncgl" }
+func (c syncGLCmd) Synopsis() string {
+	return "sync list of checked out repositories with a gitlab user/group"
+}
+
+func (c syncGLCmd) Usage() string {
+	return `repos syncgl [-archived] [-dryrun] [-prune] [-worktree] [-force] [-dir=PATH] [-base-url=URL] [-user=XXX]... [-group=XXX]...
+
+Authentication uses the GL_TOKEN environent variable.
+`
+}
+
+func (c *syncGLCmd) SetFlags(fset *flag.FlagSet) {
+	fset.BoolVar(&c.archived, "archived", false, "include archived repositories")
+	fset.BoolVar(&c.dryRun, "dryrun", false, "print actions instead of executing them")
+	fset.BoolVar(&c.prune, "prune", false, "prune repositories not found on the remote")
+	fset.BoolVar(&c.worktree, "worktree", false, "nest checkouts under repo/default")
+	fset.BoolVar(&c.force, "force", false, "ignore the lock held by another sync")
+	fset.StringVar(&c.dir, "dir", envOr(RootEnv, "."), "directory of repos to sync, defaults to $"+RootEnv+" or the current directory")
+	fset.StringVar(&c.baseURL, "base-url", "https://gitlab.com", "gitlab instance url")
+	fset.Func("user", "gitlab user", func(s string) error {
+		c.users = append(c.users, s)
+		return nil
+	})
+	fset.Func("group", "gitlab group", func(s string) error {
+		c.groups = append(c.groups, s)
+		return nil
+	})
+	fset.Func("exclude", "glob pattern against repo name to exclude, repeatable", func(s string) error {
+		c.exclude = append(c.exclude, s)
+		return nil
+	})
+}
+
+func (c syncGLCmd) Execute(ctx context.Context, fset *flag.FlagSet, args ...any) subcommands.ExitStatus {
+	if fset.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "repos syncgl: unexpected args:", args)
+		return subcommands.ExitUsageError
+	}
+
+	if len(c.groups)+len(c.users) == 0 {
+		fmt.Fprintln(os.Stderr, "no users or groups given")
+		return subcommands.ExitUsageError
+	}
+
+	// cancel in flight git commands on interrupt, so the lock is released
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	release, err := acquireLock(c.dir, c.force)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "repos syncgl:", err)
+		return subcommands.ExitFailure
+	}
+	defer release()
+
+	err = c.run(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "repos syncgl:", err)
+		return subcommands.ExitFailure
+	}
+
+	synccmd := syncCmd{
+		dir:      c.dir,
+		parallel: 5,
+	}
+	err = synccmd.run(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "repos sync:", err)
+		return subcommands.ExitFailure
+	}
+
+	return subcommands.ExitSuccess
+}
+
+// glProject holds the fields of a gitlab project we use.
+type glProject struct {
+	Path          string `json:"path"`
+	Archived      bool   `json:"archived"`
+	HTTPURLToRepo string `json:"http_url_to_repo"`
+	Namespace     struct {
+		FullPath string `json:"full_path"`
+	} `json:"namespace"`
+}
+
+func (c syncGLCmd) run(ctx context.Context) error {
+	allReposM := make(map[string]remoteRepo)
+	for _, user := range c.users {
+		err := c.listProjects(ctx, allReposM, "users/"+url.PathEscape(user)+"/projects")
+		if err != nil {
+			return err
+		}
+	}
+	for _, group := range c.groups {
+		err := c.listProjects(ctx, allReposM, "groups/"+url.PathEscape(group)+"/projects")
+		if err != nil {
+			return err
+		}
+	}
+
+	// reuse the syncgh clone and prune logic
+	gh := syncGHCmd{
+		dryRun:    c.dryRun,
+		prune:     c.prune,
+		worktree:  c.worktree,
+		parallel:  5,
+		scanDir:   c.dir,
+		outputDir: c.dir,
+	}
+	return gh.reconcile(ctx, allReposM)
+}
+
+// listProjects pages through a gitlab project listing endpoint,
+// following the X-Next-Page header.
+func (c syncGLCmd) listProjects(ctx context.Context, m map[string]remoteRepo, endpoint string) error {
+	for page := "1"; page != ""; {
+		q := url.Values{
+			"per_page": {"100"},
+			"page":     {page},
+		}
+		if !c.archived {
+			q.Set("archived", "false")
+		}
+		u := strings.TrimSuffix(c.baseURL, "/") + "/api/v4/" + endpoint + "?" + q.Encode()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return fmt.Errorf("create request for %s: %w", endpoint, err)
+		}
+		if token := os.Getenv(GitlabTokenEnv); token != "" {
+			req.Header.Set("PRIVATE-TOKEN", token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return withKind(ErrGitLabAPI, fmt.Errorf("list %s page %s: %w", endpoint, page, err))
+		}
+		var projects []glProject
+		if res.StatusCode != http.StatusOK {
+			err = fmt.Errorf("unexpected status %s", res.Status)
+		} else {
+			err = json.NewDecoder(res.Body).Decode(&projects)
+		}
+		res.Body.Close()
+		if err != nil {
+			return withKind(ErrGitLabAPI, fmt.Errorf("list %s page %s: %w", endpoint, page, err))
+		}
+
+	projectLoop:
+		for _, p := range projects {
+			if !c.archived && p.Archived {
+				continue
+			}
+			for _, pattern := range c.exclude {
+				ok, err := filepath.Match(pattern, p.Path)
+				if err != nil {
+					return fmt.Errorf("match exclude pattern %q against %q: %w", pattern, p.Path, err)
+				} else if ok {
+					continue projectLoop
+				}
+			}
+			m[p.Path] = remoteRepo{owner: p.Namespace.FullPath, url: p.HTTPURLToRepo}
+		}
+
+		page = res.Header.Get("X-Next-Page")
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/google/subcommands"
+)
+
+// Set with -ldflags "-X main.version=... -X main.commit=... -X main.date=..."
+// for release builds, otherwise filled in from the build info.
+var (
+	version string
+	commit  string
+	date    string
+)
+
+type versionCmd struct {
+	json bool
+}
+
+func (c versionCmd) Name() string     { return "version" }
+func (c versionCmd) Synopsis() string { return "print the version of repos" }
+func (c versionCmd) Usage() string    { return "repos version [-json]\n" }
+func (c *versionCmd) SetFlags(fset *flag.FlagSet) {
+	fset.BoolVar(&c.json, "json", false, "print json to stdout")
+}
+
+type versionInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	Date    string `json:"date"`
+	Go      string `json:"go"`
+}
+
+func (c versionCmd) Execute(ctx context.Context, fset *flag.FlagSet, args ...any) subcommands.ExitStatus {
+	if fset.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "repos version: unexpected args:", args)
+		return subcommands.ExitUsageError
+	}
+
+	info := buildVersion()
+	if c.json {
+		err := json.NewEncoder(os.Stdout).Encode(info)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "repos version:", err)
+			return subcommands.ExitFailure
+		}
+		return subcommands.ExitSuccess
+	}
+	fmt.Fprintf(os.Stderr, "repos %s\ncommit: %s\ndate: %s\ngo: %s\n", info.Version, info.Commit, info.Date, info.Go)
+	return subcommands.ExitSuccess
+}
+
+// buildVersion prefers the ldflags values, falling back to the module version
+// and vcs stamps recorded by the go tool.
+func buildVersion() versionInfo {
+	info := versionInfo{
+		Version: version,
+		Commit:  commit,
+		Date:    date,
+		Go:      runtime.Version(),
+	}
+	var modified bool
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if info.Date == "" {
+					info.Date = s.Value
+				}
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+	}
+	if modified && commit == "" {
+		info.Commit += "-dirty"
+	}
+	for _, v := range []*string{&info.Version, &info.Commit, &info.Date} {
+		if *v == "" {
+			*v = "unknown"
+		}
+	}
+	return info
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/subcommands"
+)
+
+type whoamiCmd struct{}
+
+func (c whoamiCmd) Name() string                { return "whoami" }
+func (c whoamiCmd) Synopsis() string            { return "show the github user and scopes of GH_TOKEN" }
+func (c whoamiCmd) Usage() string               { return "repos whoami\n" }
+func (c whoamiCmd) SetFlags(fset *flag.FlagSet) {}
+func (c whoamiCmd) Execute(ctx context.Context, fset *flag.FlagSet, args ...any) subcommands.ExitStatus {
+	if fset.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "repos whoami: unexpected args:", args)
+		return subcommands.ExitUsageError
+	}
+	err := c.run(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "repos whoami:", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+func (c whoamiCmd) run(ctx context.Context) error {
+	client, err := newGitHubClient(ctx, githubApp{}, os.Getenv(GithubBaseURLEnv))
+	if err != nil {
+		return err
+	}
+	user, res, err := client.Users.Get(ctx, "")
+	if err != nil {
+		return withKind(ErrGitHubAPI, fmt.Errorf("get authenticated user: %w", err))
+	}
+
+	scopes := res.Header.Get("X-OAuth-Scopes")
+	if scopes == "" {
+		scopes = "(none reported)"
+	}
+	fmt.Fprintf(os.Stderr, "token: %s\n", redactEnv(GithubTokenEnv))
+	fmt.Fprintf(os.Stderr, "login: %s\n", user.GetLogin())
+	fmt.Fprintf(os.Stderr, "scopes: %s\n", scopes)
+	fmt.Fprintf(os.Stderr, "rate limit: %d/%d remaining, resets in %s\n",
+		res.Rate.Remaining, res.Rate.Limit, time.Until(res.Rate.Reset.Time).Round(time.Second))
+	return nil
+}
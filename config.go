@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// config holds defaults read from the config file,
+// flags given on the command line take precedence.
+type config struct {
+	Users    []string `json:"users"`
+	Orgs     []string `json:"orgs"`
+	Archived bool     `json:"archived"`
+	Worktree bool     `json:"worktree"`
+	Prune    bool     `json:"prune"`
+
+	// test repos for new and last
+	TestDir      string `json:"test_dir"`
+	Prefix       string `json:"prefix"`
+	NameTemplate string `json:"name_template"`
+	NewHook      string `json:"new_hook"`
+	TemplateDir  string `json:"template_dir"`
+}
+
+// defaultConfigPath is config.json in the user config dir,
+// or empty if there is no config dir.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "repos", "config.json")
+}
+
+// defaultTemplateDir is where new looks for templates overriding the builtin ones,
+// next to the config file.
+func defaultTemplateDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "repos", "templates")
+}
+
+// loadConfig reads the config file at fp,
+// a missing file is only an error if it was asked for explicitly.
+func loadConfig(fp string, explicit bool) (config, error) {
+	var conf config
+	if fp == "" {
+		return conf, nil
+	}
+	b, err := os.ReadFile(fp)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return conf, nil
+	} else if err != nil {
+		return conf, fmt.Errorf("read config: %w", err)
+	}
+	err = json.Unmarshal(b, &conf)
+	if err != nil {
+		return conf, fmt.Errorf("parse config %s: %w", fp, err)
+	}
+	return conf, nil
+}
+
+// testRepoConfig fills in the test repo settings not given as flags
+// from the default config file, which is returned for other settings.
+func testRepoConfig(fset *flag.FlagSet, dir, prefix, nameTpl *string) (config, error) {
+	conf, err := loadConfig(defaultConfigPath(), false)
+	if err != nil {
+		return conf, err
+	}
+	set := setFlags(fset)
+	if !set["test-dir"] && conf.TestDir != "" {
+		*dir = conf.TestDir
+	}
+	if !set["prefix"] && conf.Prefix != "" {
+		*prefix = conf.Prefix
+	}
+	if !set["name-template"] && conf.NameTemplate != "" {
+		*nameTpl = conf.NameTemplate
+	}
+	return conf, nil
+}
+
+// setFlags returns the names of the flags given on the command line.
+func setFlags(fset *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fset.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
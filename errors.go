@@ -0,0 +1,63 @@
+package main
+
+import "errors"
+
+// Failure kinds, matched with errors.Is.
+// The human readable message comes from the wrapped error.
+var (
+	ErrNoGitDir          = errors.New("no git dir found")
+	ErrRemoteUnreachable = errors.New("remote unreachable")
+	ErrNoDefaultBranch   = errors.New("no default branch")
+	ErrCheckout          = errors.New("checkout failed")
+	ErrFetch             = errors.New("fetch failed")
+	ErrMergeConflict     = errors.New("merge failed")
+	ErrGit               = errors.New("git command failed")
+	ErrGitHubAPI         = errors.New("github api request failed")
+	ErrGitLabAPI         = errors.New("gitlab api request failed")
+	ErrGoMod             = errors.New("go mod init failed")
+	ErrTemplate          = errors.New("template render failed")
+	ErrDrift             = errors.New("local repos differ from remote")
+)
+
+// errorKinds names the failure kinds for machine readable output.
+var errorKinds = []struct {
+	name string
+	err  error
+}{
+	{"no_git_dir", ErrNoGitDir},
+	{"remote_unreachable", ErrRemoteUnreachable},
+	{"no_default_branch", ErrNoDefaultBranch},
+	{"checkout", ErrCheckout},
+	{"fetch", ErrFetch},
+	{"merge_conflict", ErrMergeConflict},
+	{"git", ErrGit},
+	{"github_api", ErrGitHubAPI},
+	{"gitlab_api", ErrGitLabAPI},
+	{"go_mod", ErrGoMod},
+	{"template", ErrTemplate},
+	{"drift", ErrDrift},
+}
+
+// errorKind returns the name of the first failure kind err matches,
+// or empty if it has none.
+func errorKind(err error) string {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			return k.name
+		}
+	}
+	return ""
+}
+
+// kindError tags err with a failure kind without changing its message.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e kindError) Error() string   { return e.err.Error() }
+func (e kindError) Unwrap() []error { return []error{e.kind, e.err} }
+
+func withKind(kind, err error) error {
+	return kindError{kind, err}
+}
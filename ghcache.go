@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// etagTransport makes GET requests conditional on the ETag of the last response,
+// replaying the cached response when github answers 304 Not Modified.
+// Conditional requests that hit don't count against the rate limit.
+type etagTransport struct {
+	base http.RoundTripper
+	dir  string
+}
+
+// etagEntry is a cached response.
+type etagEntry struct {
+	ETag   string      `json:"etag"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// newETagTransport caches responses under the user cache dir.
+func newETagTransport(base http.RoundTripper) (*etagTransport, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, fmt.Errorf("get cache dir: %w", err)
+	}
+	// cached bodies can include private repos, keep them to the user
+	dir := filepath.Join(cacheDir, "repos", "github")
+	err = os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("mkdir %s: %w", dir, err)
+	}
+	// tighten caches made by older versions
+	err = os.Chmod(dir, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("chmod %s: %w", dir, err)
+	}
+	return &etagTransport{base: base, dir: dir}, nil
+}
+
+func (t *etagTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.base.RoundTrip(req)
+	}
+
+	// responses vary by token, but github checks the etag against
+	// what it would send this token, so the url alone is enough of a key
+	sum := sha256.Sum256([]byte(req.URL.String()))
+	fp := filepath.Join(t.dir, hex.EncodeToString(sum[:8])+".json")
+	var entry etagEntry
+	cached := false
+	if b, err := os.ReadFile(fp); err == nil && json.Unmarshal(b, &entry) == nil && entry.ETag != "" {
+		cached = true
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", entry.ETag)
+	}
+
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case res.StatusCode == http.StatusNotModified && cached:
+		res.Body.Close()
+		debugf("github cache hit: %s\n", req.URL)
+		// keep the fresh rate limit headers
+		header := entry.Header.Clone()
+		for k, v := range res.Header {
+			header[k] = v
+		}
+		return &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         res.Proto,
+			ProtoMajor:    res.ProtoMajor,
+			ProtoMinor:    res.ProtoMinor,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(entry.Body)),
+			ContentLength: int64(len(entry.Body)),
+			Request:       req,
+		}, nil
+
+	case res.StatusCode == http.StatusOK && res.Header.Get("ETag") != "":
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		res.Body = io.NopCloser(bytes.NewReader(body))
+		t.store(fp, etagEntry{ETag: res.Header.Get("ETag"), Header: res.Header, Body: body})
+	}
+	return res, nil
+}
+
+// store writes entry to fp, a failed write only costs a full request next time.
+func (t *etagTransport) store(fp string, entry etagEntry) {
+	b, err := json.Marshal(entry)
+	if err == nil {
+		tmp := fp + ".tmp"
+		err = os.WriteFile(tmp, b, 0o600)
+		if err == nil {
+			err = os.Rename(tmp, fp)
+		}
+	}
+	if err != nil {
+		debugf("github cache: write %s: %v\n", fp, err)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v48/github"
+	"golang.org/x/oauth2"
+)
+
+const (
+	GithubTokenEnv   = "GH_TOKEN"
+	GithubBaseURLEnv = "GH_BASE_URL"
+
+	GithubAppIDEnv             = "GH_APP_ID"
+	GithubAppInstallationIDEnv = "GH_APP_INSTALLATION_ID"
+	GithubAppKeyEnv            = "GH_APP_PRIVATE_KEY_PATH"
+
+	githubAttempts = 3
+)
+
+// githubRetryDelay is the first backoff between attempts, doubling each time.
+var githubRetryDelay = time.Second
+
+// githubApp holds credentials for authenticating as a github app installation.
+type githubApp struct {
+	appID          int64
+	installationID int64
+	keyPath        string
+}
+
+func (a *githubApp) SetFlags(fset *flag.FlagSet) {
+	envInt := func(key string) int64 {
+		i, _ := strconv.ParseInt(os.Getenv(key), 10, 64)
+		return i
+	}
+	fset.Int64Var(&a.appID, "app-id", envInt(GithubAppIDEnv), "github app id, defaults to $"+GithubAppIDEnv)
+	fset.Int64Var(&a.installationID, "app-installation-id", envInt(GithubAppInstallationIDEnv), "github app installation id, defaults to $"+GithubAppInstallationIDEnv)
+	fset.StringVar(&a.keyPath, "app-key", os.Getenv(GithubAppKeyEnv), "path to the github app private key, defaults to $"+GithubAppKeyEnv)
+}
+
+func (a githubApp) configured() bool {
+	return a.appID != 0 || a.installationID != 0 || a.keyPath != ""
+}
+
+// newGitHubClient returns a github client authenticated as the app installation if configured,
+// falling back to GH_TOKEN.
+// Requests go through the http client in ctx under oauth2.HTTPClient if set.
+// A non empty baseURL points the client at a github enterprise instance.
+func newGitHubClient(ctx context.Context, app githubApp, baseURL string) (*github.Client, error) {
+	token := os.Getenv(GithubTokenEnv)
+	if app.configured() {
+		var err error
+		token, err = app.installationToken(ctx, baseURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ts := oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: token},
+	)
+	return githubClient(oauth2.NewClient(ctx, ts), baseURL)
+}
+
+func githubClient(hc *http.Client, baseURL string) (*github.Client, error) {
+	if baseURL == "" {
+		return github.NewClient(hc), nil
+	}
+	client, err := github.NewEnterpriseClient(baseURL, baseURL, hc)
+	if err != nil {
+		return nil, fmt.Errorf("github enterprise client for %s: %w", baseURL, err)
+	}
+	return client, nil
+}
+
+// githubHost is the host repos are cloned from,
+// github.com or the host of the enterprise baseURL.
+func githubHost(baseURL string) string {
+	if baseURL == "" {
+		return "github.com"
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host == "" {
+		return "github.com"
+	}
+	return u.Host
+}
+
+// installationToken exchanges a jwt signed with the app's key for an installation token.
+func (a githubApp) installationToken(ctx context.Context, baseURL string) (string, error) {
+	if a.appID == 0 || a.installationID == 0 || a.keyPath == "" {
+		return "", fmt.Errorf("github app: need all of app id, installation id and private key")
+	}
+	b, err := os.ReadFile(a.keyPath)
+	if err != nil {
+		return "", fmt.Errorf("github app: read key: %w", err)
+	}
+	key, err := parseRSAKey(b)
+	if err != nil {
+		return "", fmt.Errorf("github app: parse key %s: %w", a.keyPath, err)
+	}
+	jwt, err := appJWT(a.appID, key, time.Now())
+	if err != nil {
+		return "", fmt.Errorf("github app: sign jwt: %w", err)
+	}
+
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: jwt})
+	client, err := githubClient(oauth2.NewClient(ctx, ts), baseURL)
+	if err != nil {
+		return "", err
+	}
+	tok, _, err := client.Apps.CreateInstallationToken(ctx, a.installationID, nil)
+	if err != nil {
+		return "", withKind(ErrGitHubAPI, fmt.Errorf("github app: create installation token: %w", err))
+	}
+	return tok.GetToken(), nil
+}
+
+func parseRSAKey(b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no pem block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := k.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("not an rsa key")
+	}
+	return key, nil
+}
+
+// appJWT creates the short lived RS256 jwt github apps authenticate with.
+func appJWT(appID int64, key *rsa.PrivateKey, now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]int64{
+		"iat": now.Add(-time.Minute).Unix(), // allow for clock drift
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": appID,
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + enc.EncodeToString(sig), nil
+}
+
+// retryGitHub calls f up to githubAttempts times with exponential backoff,
+// retrying only server errors and failures to get a response.
+// When rate limited, or the rate limit is exhausted, it first waits for the limit to reset,
+// unless that would take longer than maxWait.
+func retryGitHub(ctx context.Context, maxWait time.Duration, f func() (*github.Response, error)) error {
+	delay := githubRetryDelay
+	for attempt := 1; ; attempt++ {
+		res, err := f()
+		wait, limited := rateLimitWait(res, err, time.Now())
+		if limited && wait <= maxWait && (err == nil || attempt < githubAttempts) {
+			fmt.Fprintf(os.Stderr, "github rate limited, waiting %v\n", wait.Round(time.Second))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			if err == nil {
+				return nil
+			}
+			continue
+		}
+		if err == nil || attempt >= githubAttempts || !transientGitHubError(res, err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// rateLimitWait reports how long to wait before calling the api again,
+// if the call was rate limited or used up the remaining requests.
+func rateLimitWait(res *github.Response, err error, now time.Time) (time.Duration, bool) {
+	var rateErr *github.RateLimitError
+	var abuseErr *github.AbuseRateLimitError
+	switch {
+	case errors.As(err, &rateErr):
+		return rateErr.Rate.Reset.Sub(now), true
+	case errors.As(err, &abuseErr):
+		if abuseErr.RetryAfter != nil {
+			return *abuseErr.RetryAfter, true
+		}
+		return time.Minute, true
+	case err == nil && res != nil && res.Rate.Limit > 0 && res.Rate.Remaining == 0:
+		return res.Rate.Reset.Sub(now), true
+	}
+	return 0, false
+}
+
+func transientGitHubError(res *github.Response, err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var rateErr *github.RateLimitError
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &rateErr) || errors.As(err, &abuseErr) {
+		return false
+	}
+	if res == nil || res.Response == nil {
+		// network error
+		return true
+	}
+	return res.StatusCode >= 500
+}
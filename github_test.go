@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v48/github"
+)
+
+func githubResponse(status int) *github.Response {
+	return &github.Response{Response: &http.Response{StatusCode: status}}
+}
+
+func TestTransientGitHubError(t *testing.T) {
+	errAPI := errors.New("api error")
+	tests := []struct {
+		name string
+		res  *github.Response
+		err  error
+		want bool
+	}{
+		{"network error", nil, errors.New("connection reset"), true},
+		{"no http response", &github.Response{}, errors.New("connection reset"), true},
+		{"500", githubResponse(500), errAPI, true},
+		{"502", githubResponse(502), errAPI, true},
+		{"404", githubResponse(404), errAPI, false},
+		{"401", githubResponse(401), errAPI, false},
+		{"canceled", nil, context.Canceled, false},
+		{"deadline", nil, fmt.Errorf("get: %w", context.DeadlineExceeded), false},
+		{"rate limited", githubResponse(403), &github.RateLimitError{}, false},
+		{"abuse limited", githubResponse(403), &github.AbuseRateLimitError{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := transientGitHubError(tt.res, tt.err)
+			if got != tt.want {
+				t.Errorf("transientGitHubError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitWait(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	retryAfter := 30 * time.Second
+	tests := []struct {
+		name        string
+		res         *github.Response
+		err         error
+		wantWait    time.Duration
+		wantLimited bool
+	}{
+		{
+			name:        "rate limit error",
+			err:         &github.RateLimitError{Rate: github.Rate{Reset: github.Timestamp{Time: now.Add(time.Minute)}}},
+			wantWait:    time.Minute,
+			wantLimited: true,
+		}, {
+			name:        "abuse with retry after",
+			err:         &github.AbuseRateLimitError{RetryAfter: &retryAfter},
+			wantWait:    retryAfter,
+			wantLimited: true,
+		}, {
+			name:        "abuse without retry after",
+			err:         &github.AbuseRateLimitError{},
+			wantWait:    time.Minute,
+			wantLimited: true,
+		}, {
+			name: "requests used up",
+			res: &github.Response{Rate: github.Rate{
+				Limit:     5000,
+				Remaining: 0,
+				Reset:     github.Timestamp{Time: now.Add(10 * time.Minute)},
+			}},
+			wantWait:    10 * time.Minute,
+			wantLimited: true,
+		}, {
+			name: "requests remaining",
+			res: &github.Response{Rate: github.Rate{
+				Limit:     5000,
+				Remaining: 1,
+				Reset:     github.Timestamp{Time: now.Add(10 * time.Minute)},
+			}},
+		}, {
+			name: "no rate info",
+			res:  &github.Response{},
+		}, {
+			name: "other error",
+			res:  githubResponse(500),
+			err:  errors.New("server error"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait, limited := rateLimitWait(tt.res, tt.err, now)
+			if wait != tt.wantWait || limited != tt.wantLimited {
+				t.Errorf("rateLimitWait() = %v, %v, want %v, %v", wait, limited, tt.wantWait, tt.wantLimited)
+			}
+		})
+	}
+}
+
+func TestRetryGitHub(t *testing.T) {
+	delay := githubRetryDelay
+	t.Cleanup(func() { githubRetryDelay = delay })
+	githubRetryDelay = time.Millisecond
+	short := 10 * time.Millisecond
+
+	type call struct {
+		res *github.Response
+		err error
+	}
+	errServer := errors.New("server error")
+	errClient := errors.New("not found")
+	limited := func(d time.Duration) error {
+		return &github.RateLimitError{Rate: github.Rate{Reset: github.Timestamp{Time: time.Now().Add(d)}}}
+	}
+	tests := []struct {
+		name      string
+		maxWait   time.Duration
+		calls     []call
+		wantCalls int
+		wantErr   bool
+	}{
+		{
+			name:      "success",
+			calls:     []call{{githubResponse(200), nil}},
+			wantCalls: 1,
+		}, {
+			name:      "5xx then success",
+			calls:     []call{{githubResponse(502), errServer}, {githubResponse(200), nil}},
+			wantCalls: 2,
+		}, {
+			name:      "5xx every attempt",
+			calls:     []call{{githubResponse(500), errServer}, {githubResponse(500), errServer}, {githubResponse(500), errServer}},
+			wantCalls: githubAttempts,
+			wantErr:   true,
+		}, {
+			name:      "4xx not retried",
+			calls:     []call{{githubResponse(404), errClient}},
+			wantCalls: 1,
+			wantErr:   true,
+		}, {
+			name:      "network error then success",
+			calls:     []call{{nil, errServer}, {githubResponse(200), nil}},
+			wantCalls: 2,
+		}, {
+			name:      "rate limited within max wait",
+			maxWait:   time.Second,
+			calls:     []call{{githubResponse(403), limited(short)}, {githubResponse(200), nil}},
+			wantCalls: 2,
+		}, {
+			name:      "rate limited beyond max wait",
+			maxWait:   short,
+			calls:     []call{{githubResponse(403), limited(time.Hour)}},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var n int
+			err := retryGitHub(context.Background(), tt.maxWait, func() (*github.Response, error) {
+				c := tt.calls[n]
+				n++
+				return c.res, c.err
+			})
+			if n != tt.wantCalls {
+				t.Errorf("retryGitHub() made %d calls, want %d", n, tt.wantCalls)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("retryGitHub() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// hostLimiter caps the concurrent network operations against each remote host,
+// independent of the number of workers doing local work.
+// A nil hostLimiter, or one with n <= 0, doesn't limit.
+type hostLimiter struct {
+	n    int
+	mu   sync.Mutex
+	sems map[string]chan struct{}
+}
+
+func newHostLimiter(n int) *hostLimiter {
+	return &hostLimiter{n: n, sems: make(map[string]chan struct{})}
+}
+
+func (l *hostLimiter) limited() bool {
+	return l != nil && l.n > 0
+}
+
+// acquire waits for a slot for host, returning the func to release it.
+// If ctx is done first, nothing is held and the caller's git command fails on ctx.
+func (l *hostLimiter) acquire(ctx context.Context, host string) func() {
+	if !l.limited() {
+		return func() {}
+	}
+	l.mu.Lock()
+	sem, ok := l.sems[host]
+	if !ok {
+		sem = make(chan struct{}, l.n)
+		l.sems[host] = sem
+	}
+	l.mu.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }
+	case <-ctx.Done():
+		return func() {}
+	}
+}
+
+// acquireOrigin is acquire for the host of wd's origin remote.
+func (l *hostLimiter) acquireOrigin(ctx context.Context, wd string) func() {
+	if !l.limited() {
+		return func() {}
+	}
+	cmd := exec.CommandContext(ctx, "git", "remote", "get-url", "origin")
+	cmd.Dir = wd
+	out, _ := cmd.Output()
+	return l.acquire(ctx, remoteHost(strings.TrimSpace(string(out))))
+}
+
+// remoteHost returns the host of a git remote url,
+// either a url or scp like user@host:path.
+// Local paths have no host and share the "" slot.
+func remoteHost(remote string) string {
+	if strings.Contains(remote, "://") {
+		u, err := url.Parse(remote)
+		if err != nil {
+			return ""
+		}
+		return u.Hostname()
+	}
+	host, _, ok := strings.Cut(remote, ":")
+	if !ok || strings.Contains(host, "/") {
+		return ""
+	}
+	if _, h, ok := strings.Cut(host, "@"); ok {
+		host = h
+	}
+	return host
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+)
+
+// cloneJournal records the clones started and finished by syncgh,
+// so a resumed run can tell half finished clones from complete ones.
+// The journal is removed once a run completes with no unfinished clones.
+type cloneJournal struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+}
+
+// openCloneJournal starts a new journal for clones into dir,
+// replacing any left by a previous run.
+// The unfinished clones in carry are recorded as started again,
+// so a later run with -resume can still clean them up.
+func openCloneJournal(dir string, carry []string) (*cloneJournal, error) {
+	jf, err := stateFile("syncgh", dir, ".journal")
+	if err != nil {
+		return nil, fmt.Errorf("journal: %w", err)
+	}
+	f, err := os.Create(jf)
+	if err != nil {
+		return nil, fmt.Errorf("journal: create %s: %w", jf, err)
+	}
+	j := &cloneJournal{path: jf, f: f}
+	for _, repo := range carry {
+		err = j.start(repo)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// incompleteClones returns the repos whose clones into dir
+// were started but never finished by the previous run.
+func incompleteClones(dir string) ([]string, error) {
+	jf, err := stateFile("syncgh", dir, ".journal")
+	if err != nil {
+		return nil, fmt.Errorf("journal: %w", err)
+	}
+	return readCloneJournal(jf)
+}
+
+func readCloneJournal(jf string) ([]string, error) {
+	f, err := os.Open(jf)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("journal: open %s: %w", jf, err)
+	}
+	defer f.Close()
+
+	started := make(map[string]bool)
+	var order []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		op, repo, ok := strings.Cut(sc.Text(), " ")
+		if !ok {
+			continue
+		}
+		switch op {
+		case "start":
+			if _, ok := started[repo]; !ok {
+				order = append(order, repo)
+			}
+			started[repo] = true
+		case "done":
+			started[repo] = false
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("journal: read %s: %w", jf, err)
+	}
+
+	var incomplete []string
+	for _, repo := range order {
+		if started[repo] {
+			incomplete = append(incomplete, repo)
+		}
+	}
+	return incomplete, nil
+}
+
+func (j *cloneJournal) record(op, repo string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err := fmt.Fprintln(j.f, op, repo)
+	if err != nil {
+		return fmt.Errorf("journal: write %s: %w", j.path, err)
+	}
+	return nil
+}
+
+func (j *cloneJournal) start(repo string) error { return j.record("start", repo) }
+func (j *cloneJournal) done(repo string) error  { return j.record("done", repo) }
+
+// finish removes the journal after a run has gone through all its clones,
+// unless clones carried over from an earlier run are still unfinished.
+func (j *cloneJournal) finish() error {
+	j.f.Close()
+	incomplete, err := readCloneJournal(j.path)
+	if err != nil {
+		return err
+	}
+	if len(incomplete) > 0 {
+		return nil
+	}
+	err = os.Remove(j.path)
+	if err != nil {
+		return fmt.Errorf("journal: remove %s: %w", j.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadCloneJournal(t *testing.T) {
+	tests := []struct {
+		name    string
+		journal string
+		want    []string
+	}{
+		{"empty", "", nil},
+		{"all done", "start a\ndone a\nstart b\ndone b\n", nil},
+		{"unfinished", "start a\nstart b\ndone a\n", []string{"b"}},
+		{"start order", "start c\nstart a\nstart b\ndone a\n", []string{"c", "b"}},
+		{"restarted", "start a\ndone a\nstart a\n", []string{"a"}},
+		{"nested names", "start owner/repo\n", []string{"owner/repo"}},
+		{"junk lines", "start\n\nstart a\nbogus b\n", []string{"a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jf := filepath.Join(t.TempDir(), "journal")
+			err := os.WriteFile(jf, []byte(tt.journal), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := readCloneJournal(jf)
+			if err != nil {
+				t.Fatalf("readCloneJournal() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readCloneJournal() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		got, err := readCloneJournal(filepath.Join(t.TempDir(), "journal"))
+		if err != nil || got != nil {
+			t.Errorf("readCloneJournal() = %q, %v, want nil, nil", got, err)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// acquireLock takes an exclusive lock over dir for the duration of a sync,
+// so overlapping runs don't fight over the same git repos.
+// The lock is a file holding the owner's pid, kept in the user cache dir.
+// If force is set, or the owner is no longer running, an existing lock is taken over.
+func acquireLock(dir string, force bool) (release func(), err error) {
+	lf, err := stateFile("sync", dir, ".lock")
+	if err != nil {
+		return nil, fmt.Errorf("lock: %w", err)
+	}
+
+	if force {
+		err = os.Remove(lf)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("lock: remove %s: %w", lf, err)
+		}
+	}
+
+	f, err := os.OpenFile(lf, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, fs.ErrExist) {
+		b, _ := os.ReadFile(lf)
+		pid := strings.TrimSpace(string(b))
+		if !pidRunning(pid) {
+			logf("removing stale lock %s of pid %s\n", lf, pid)
+			err = os.Remove(lf)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("lock: remove %s: %w", lf, err)
+			}
+			f, err = os.OpenFile(lf, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		}
+	}
+	if errors.Is(err, fs.ErrExist) {
+		b, _ := os.ReadFile(lf)
+		pid := strings.TrimSpace(string(b))
+		abs, _ := filepath.Abs(dir)
+		return nil, fmt.Errorf("another sync is running over %s (pid %s), remove %s or use -force if it is stale", abs, pid, lf)
+	} else if err != nil {
+		return nil, fmt.Errorf("lock: create %s: %w", lf, err)
+	}
+	_, err = f.WriteString(strconv.Itoa(os.Getpid()))
+	f.Close()
+	if err != nil {
+		os.Remove(lf)
+		return nil, fmt.Errorf("lock: write %s: %w", lf, err)
+	}
+
+	return func() { os.Remove(lf) }, nil
+}
+
+// stateFile returns the path of a file in the user cache dir
+// holding state of the given kind for dir.
+func stateFile(kind, dir, ext string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("resolve %s: %w", dir, err)
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("get cache dir: %w", err)
+	}
+	stateDir := filepath.Join(cacheDir, "repos")
+	err = os.MkdirAll(stateDir, 0o755)
+	if err != nil {
+		return "", fmt.Errorf("mkdir %s: %w", stateDir, err)
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(stateDir, kind+"-"+hex.EncodeToString(sum[:8])+ext), nil
+}
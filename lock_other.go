@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+// pidRunning reports whether the process pid may still be running.
+// Without a portable way to check, every lock counts as held,
+// stale ones are removed with -force.
+func pidRunning(pid string) bool {
+	return true
+}
@@ -0,0 +1,20 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"strconv"
+	"syscall"
+)
+
+// pidRunning reports whether the process pid may still be running.
+// Anything that can't be checked, like an unparsable pid, counts as running.
+func pidRunning(pid string) bool {
+	n, err := strconv.Atoi(pid)
+	if err != nil || n <= 0 {
+		return true
+	}
+	err = syscall.Kill(n, 0)
+	return !errors.Is(err, syscall.ESRCH)
+}
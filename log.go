@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type logLevel int
+
+const (
+	logQuiet logLevel = iota - 1
+	logNormal
+	logVerbose
+)
+
+// logging is set by the -q and -v flags.
+var logging = logNormal
+
+// levelFlag is a boolean flag setting logging to level.
+type levelFlag logLevel
+
+func (f levelFlag) String() string   { return "false" }
+func (f levelFlag) IsBoolFlag() bool { return true }
+func (f levelFlag) Set(s string) error {
+	on, err := strconv.ParseBool(s)
+	if on {
+		logging = logLevel(f)
+	}
+	return err
+}
+
+// logFlags adds -q and -v to fset.
+func logFlags(fset *flag.FlagSet) {
+	fset.Var(levelFlag(logQuiet), "q", "quiet, only print errors")
+	fset.Var(levelFlag(logVerbose), "v", "verbose, also print git commands and their output")
+}
+
+// logf prints progress to stderr unless -q is set.
+func logf(format string, args ...any) {
+	if logging > logQuiet {
+		fmt.Fprintf(os.Stderr, format, args...)
+	}
+}
+
+// debugf prints details to stderr if -v is set.
+func debugf(format string, args ...any) {
+	if logging >= logVerbose {
+		fmt.Fprintf(os.Stderr, format, args...)
+	}
+}
+
+// logAction prints the line describing an action,
+// even with -q if it failed.
+func logAction(msg string, err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, msg)
+		return
+	}
+	logf("%s\n", msg)
+}
+
+// combinedOutput is cmd.CombinedOutput, printing the command and its output with -v.
+func combinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	out, err := cmd.CombinedOutput()
+	if logging >= logVerbose {
+		msg := fmt.Sprintf("%s$ %s\n%s", cmd.Dir, strings.Join(cmd.Args, " "), out)
+		if err != nil {
+			msg += err.Error() + "\n"
+		}
+		fmt.Fprint(os.Stderr, msg)
+	}
+	return out, err
+}
+
+// outputMu keeps lines from concurrent prefixWriters whole.
+var outputMu sync.Mutex
+
+// prefixWriter copies lines, and progress updates ending in \r, to stderr
+// prefixed by the name of what they're for.
+type prefixWriter struct {
+	prefix string
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexAny(w.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		w.write(w.buf[:i+1])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes out any incomplete last line.
+func (w *prefixWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.write(append(w.buf, '\n'))
+		w.buf = nil
+	}
+}
+
+func (w *prefixWriter) write(line []byte) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	fmt.Fprintf(os.Stderr, "%s: %s", w.prefix, line)
+}
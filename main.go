@@ -12,8 +12,20 @@ func main() {
 	subcommands.Register(subcommands.HelpCommand(), "")
 	subcommands.Register(&syncCmd{}, "")
 	subcommands.Register(&syncGHCmd{}, "")
+	subcommands.Register(&syncGLCmd{}, "")
 	subcommands.Register(&lastCmd{}, "")
 	subcommands.Register(&newCmd{}, "")
+	subcommands.Register(&cleanCmd{}, "")
+	subcommands.Register(&envCmd{}, "")
+	subcommands.Register(&doctorCmd{}, "")
+	subcommands.Register(&completionCmd{}, "")
+	subcommands.Register(&versionCmd{}, "")
+	subcommands.Register(&duCmd{}, "")
+	subcommands.Register(&whoamiCmd{}, "")
+	subcommands.Register(&checkUpstreamCmd{}, "")
+	subcommands.Register(&statusCmd{}, "")
+	subcommands.Register(&listCmd{}, "")
+	subcommands.Register(&starsCmd{}, "")
 
 	flag.Parse()
 	ctx := context.Background()
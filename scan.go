@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// RootEnv sets the default -dir for commands working over a directory of repos.
+	RootEnv = "REPOS_ROOT"
+
+	scanParallel = 16
+
+	// trashDirName is where syncgh moves pruned repos by default,
+	// it is never considered a repo.
+	trashDirName = ".trash"
+
+	// ignoreFileName lists repo name patterns, one per line,
+	// in the root that sync leaves alone.
+	ignoreFileName = ".reposignore"
+)
+
+// readIgnoreFile returns the patterns in baseDir's ignore file,
+// skipping blank lines and # comments.
+// A missing file has no patterns.
+func readIgnoreFile(baseDir string) ([]string, error) {
+	fp := filepath.Join(baseDir, ignoreFileName)
+	f, err := os.Open(fp)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read %s: %w", fp, err)
+	}
+	defer f.Close()
+
+	var patterns []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := filepath.Match(line, ""); err != nil {
+			return nil, fmt.Errorf("%s: bad pattern %q: %w", fp, line, err)
+		}
+		patterns = append(patterns, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", fp, err)
+	}
+	return patterns, nil
+}
+
+// scanRepoNames returns the repo directories under baseDir,
+// with byOwner these are owner/repo, two levels down.
+func scanRepoNames(baseDir string, byOwner bool) ([]string, error) {
+	names, err := scanDirs(baseDir)
+	if err != nil || !byOwner {
+		return names, err
+	}
+	var nested []string
+	for _, owner := range names {
+		repos, err := scanDirs(filepath.Join(baseDir, owner))
+		if err != nil {
+			return nil, err
+		}
+		for _, repo := range repos {
+			nested = append(nested, owner+"/"+repo)
+		}
+	}
+	return nested, nil
+}
+
+// scanDirs returns the names of the directories directly under baseDir,
+// skipping dot directories like .git and .trash.
+// Entries are stat'ed in parallel, on network filesystems each can be a round trip.
+// Symlinks to directories are followed unless they point back into baseDir,
+// which would loop or process the same repo twice.
+func scanDirs(baseDir string) ([]string, error) {
+	f, err := os.Open(baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", baseDir, err)
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", baseDir, err)
+	}
+	sort.Strings(names)
+	realBase, err := filepath.EvalSymlinks(baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", baseDir, err)
+	}
+	realBase, err = filepath.Abs(realBase)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", baseDir, err)
+	}
+
+	isDir := make([]bool, len(names))
+	forEachParallel(len(names), scanParallel, func(i int) {
+		p := filepath.Join(baseDir, names[i])
+		fi, err := os.Lstat(p)
+		if err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+			isDir[i] = isOutsideDirLink(p, realBase)
+			return
+		}
+		isDir[i] = err == nil && fi.IsDir()
+	})
+
+	var dirs []string
+	for i, name := range names {
+		if isDir[i] && !strings.HasPrefix(name, ".") {
+			dirs = append(dirs, name)
+		}
+	}
+	return dirs, nil
+}
+
+// forEachParallel calls f for each index in [0, n) from up to parallel goroutines,
+// returning once all calls are done.
+// f typically writes its result to index i of a slice.
+func forEachParallel(n, parallel int, f func(i int)) {
+	if parallel < 1 {
+		parallel = 1
+	}
+	idx := make(chan int, n)
+	for i := 0; i < n; i++ {
+		idx <- i
+	}
+	close(idx)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range idx {
+				f(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// isOutsideDirLink reports whether the symlink p resolves to a directory
+// that isn't realBase, an ancestor of it, or inside it.
+func isOutsideDirLink(p, realBase string) bool {
+	target, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return false
+	}
+	target, err = filepath.Abs(target)
+	if err != nil {
+		return false
+	}
+	fi, err := os.Stat(target)
+	if err != nil || !fi.IsDir() {
+		return false
+	}
+	within := func(dir, parent string) bool {
+		rel, err := filepath.Rel(parent, dir)
+		return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+	}
+	if within(target, realBase) || within(realBase, target) {
+		debugf("not following %s, it points back into %s\n", p, realBase)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,17 @@
+# build output
+/bin/
+/dist/
+*.exe
+*.so
+*.dylib
+
+# test binaries and profiles
+*.test
+*.out
+*.prof
+coverage.txt
+coverage.html
+
+# workspace
+go.work
+go.work.sum
@@ -0,0 +1,10 @@
+# dependencies
+node_modules/
+
+# build output
+/dist/
+/build/
+
+# logs and coverage
+npm-debug.log*
+coverage/
@@ -0,0 +1,17 @@
+# bytecode
+__pycache__/
+*.py[cod]
+
+# build output
+/build/
+/dist/
+*.egg-info/
+
+# environments
+.venv/
+venv/
+
+# test and coverage
+.pytest_cache/
+.coverage
+htmlcov/
@@ -3,6 +3,6 @@
 [![Go Reference][pkgsitebadge]][pkgsite]
 [![License][licensebadge]](LICENSE)
 
-[licensebadge]: https://img.shields.io/github/license/seankhliao/{{.Name}}.svg?style=flat-square
-[pkgsitebadge]: https://pkg.go.dev/badge/go.seankhliao.com/{{.Name}}.svg
-[pkgsite]: https://pkg.go.dev/go.seankhliao.com/{{.Name}}
+[licensebadge]: https://img.shields.io/github/license/{{.Owner}}/{{.Name}}.svg?style=flat-square
+[pkgsitebadge]: https://pkg.go.dev/badge/{{.Module}}.svg
+[pkgsite]: https://pkg.go.dev/{{.Module}}